@@ -1,8 +1,15 @@
 package db
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prologic/bitcask"
 )
@@ -12,156 +19,377 @@ const (
 	TOKEN       string = "token"
 	JWTCONFIG   string = "jwtconfig"
 	DL_DIR      string = "dl_dir"
+	SA_POOL     string = "sapool:"
 )
 
-func getDb(dbPath string) *bitcask.Bitcask {
-	db, err := bitcask.Open(dbPath)
-	if err != nil {
-		log.Fatal(err)
+// ErrKeyNotFound is returned by the Get functions when nothing is stored.
+var ErrKeyNotFound = bitcask.ErrKeyNotFound
+
+// DEFAULT_SA_POOL is the pool setsa stores a directory under unless told
+// otherwise, and the one --usesa picks up.
+const DEFAULT_SA_POOL string = "default"
+
+// ServiceAccount is one service-account JSON file of a pool.
+type ServiceAccount struct {
+	Name   string `json:"name"`
+	Config []byte `json:"config"`
+}
+
+// DB_LOCK_TIMEOUT is how long opening the database waits for another run
+// that has it open.
+const DB_LOCK_TIMEOUT time.Duration = 10 * time.Second
+
+// bitcask locks its directory while it's open, so opening it twice in one
+// process fails just like it does across processes. dbMut makes opens in this
+// process take turns, other processes only ever hold it for a moment.
+var dbMut sync.Mutex
+
+// handle is an open database, closing it lets the next one open it.
+type handle struct {
+	*bitcask.Bitcask
+}
+
+func (h *handle) Close() error {
+	defer dbMut.Unlock()
+	return h.Bitcask.Close()
+}
+
+func getDb(dbPath string) (*handle, error) {
+	dbMut.Lock()
+	deadline := time.Now().Add(DB_LOCK_TIMEOUT)
+	for {
+		db, err := bitcask.Open(dbPath)
+		if err == nil {
+			return &handle{db}, nil
+		}
+		if err != bitcask.ErrDatabaseLocked || time.Now().After(deadline) {
+			dbMut.Unlock()
+			return nil, fmt.Errorf("unable to open database %s: %w", dbPath, err)
+		}
+		time.Sleep(20 * time.Millisecond)
 	}
-	return db
 }
 
-func AddCredentialsDb(dbPath string, credsPath string) (bool, error) {
-	db := getDb(dbPath)
+func AddCredentialsDb(p Profile, credsPath string) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
 	data, err := ioutil.ReadFile(credsPath)
 	if err != nil {
 		return false, err
 	}
-	err = db.Put([]byte(CREDENTIALS), data)
+	err = db.Put(p.key(CREDENTIALS), data)
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func AddTokenDb(dbPath string, tok []byte) (bool, error) {
-	db := getDb(dbPath)
+func AddTokenDb(p Profile, tok []byte) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
-	err := db.Put([]byte(TOKEN), tok)
+	err = db.Put(p.key(TOKEN), tok)
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func AddJWTConfigDb(dbPath string, configPath string) (bool, error) {
-	db := getDb(dbPath)
+func AddJWTConfigDb(p Profile, configPath string) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
 	data, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return false, err
 	}
-	err = db.Put([]byte(JWTCONFIG), data)
+	err = db.Put(p.key(JWTCONFIG), data)
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func GetCredentialsDb(dbPath string) ([]byte, error) {
-	db := getDb(dbPath)
+func GetCredentialsDb(p Profile) ([]byte, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return nil, err
+	}
 	defer db.Close()
-	data, err := db.Get([]byte(CREDENTIALS))
+	data, err := db.Get(p.key(CREDENTIALS))
 	if err != nil {
 		return nil, err
 	}
 	return data, nil
 }
 
-func GetTokenDb(dbPath string) ([]byte, error) {
-	db := getDb(dbPath)
+func GetTokenDb(p Profile) ([]byte, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return nil, err
+	}
 	defer db.Close()
-	data, err := db.Get([]byte(TOKEN))
+	data, err := db.Get(p.key(TOKEN))
 	if err != nil {
 		return nil, err
 	}
 	return data, nil
 }
 
-func GetJWTConfigDb(dbPath string) ([]byte, error) {
-	db := getDb(dbPath)
+func GetJWTConfigDb(p Profile) ([]byte, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return nil, err
+	}
 	defer db.Close()
-	data, err := db.Get([]byte(JWTCONFIG))
+	data, err := db.Get(p.key(JWTCONFIG))
 	if err != nil {
 		return nil, err
 	}
 	return data, nil
 }
 
-func IsCredentialsInDb(dbPath string) bool {
-	db := getDb(dbPath)
+func IsCredentialsInDb(p Profile) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
-	return db.Has([]byte(CREDENTIALS))
+	return db.Has(p.key(CREDENTIALS)), nil
 }
 
-func IsTokenInDb(dbPath string) bool {
-	db := getDb(dbPath)
+func IsTokenInDb(p Profile) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
-	return db.Has([]byte(TOKEN))
+	return db.Has(p.key(TOKEN)), nil
 }
 
-func IsJWTConfigInDb(dbPath string) bool {
-	db := getDb(dbPath)
+func IsJWTConfigInDb(p Profile) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
-	return db.Has([]byte(JWTCONFIG))
+	return db.Has(p.key(JWTCONFIG)), nil
 }
 
-func RemoveCredentialsDb(dbPath string) (bool, error) {
-	db := getDb(dbPath)
+func RemoveCredentialsDb(p Profile) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
-	err := db.Delete([]byte(CREDENTIALS))
+	err = db.Delete(p.key(CREDENTIALS))
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func RemoveTokenDb(dbPath string) (bool, error) {
-	db := getDb(dbPath)
+func RemoveTokenDb(p Profile) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
-	err := db.Delete([]byte(TOKEN))
+	err = db.Delete(p.key(TOKEN))
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func RemoveJWTConfigDb(dbPath string) (bool, error) {
-	db := getDb(dbPath)
+func RemoveJWTConfigDb(p Profile) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
-	err := db.Delete([]byte(JWTCONFIG))
+	err = db.Delete(p.key(JWTCONFIG))
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func AddDLDirDb(dbPath string, dir_path string) (bool, error) {
-	db := getDb(dbPath)
+func AddDLDirDb(p Profile, dir_path string) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
-	err := db.Put([]byte(DL_DIR), []byte(dir_path))
+	err = db.Put(p.key(DL_DIR), []byte(dir_path))
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func GetDLDirDb(dbPath string) (string, error) {
-	db := getDb(dbPath)
+func GetDLDirDb(p Profile) (string, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return ".", err
+	}
 	defer db.Close()
-	data, err := db.Get([]byte(DL_DIR))
+	data, err := db.Get(p.key(DL_DIR))
 	if err != nil {
 		return ".", err
 	}
 	return string(data), nil
 }
 
-func RemoveDLDirDb(dbPath string) (bool, error) {
-	db := getDb(dbPath)
+func RemoveDLDirDb(p Profile) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+	err = db.Delete(p.key(DL_DIR))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AddSAPoolDb stores every .json file in dirPath as the service-account pool
+// name, replacing an existing pool of that name.
+func AddSAPoolDb(p Profile, name string, dirPath string) (int, error) {
+	err := validName("pool", name)
+	if err != nil {
+		return 0, err
+	}
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	var accounts []ServiceAccount
+	for _, f := range files {
+		if f.IsDir() || !strings.EqualFold(filepath.Ext(f.Name()), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dirPath, f.Name()))
+		if err != nil {
+			return 0, err
+		}
+		accounts = append(accounts, ServiceAccount{Name: f.Name(), Config: data})
+	}
+	if len(accounts) == 0 {
+		return 0, fmt.Errorf("no service account files found in %s", dirPath)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Name < accounts[j].Name
+	})
+	db, err := getDb(p.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	err = deleteSAPool(db, p, name)
+	if err != nil {
+		return 0, err
+	}
+	// One key per account, a whole pool easily exceeds the value size limit.
+	for i, account := range accounts {
+		data, err := json.Marshal(account)
+		if err != nil {
+			return 0, err
+		}
+		err = db.Put(p.key(saPoolKey(name, i)), data)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = db.Put(p.key(SA_POOL+name), []byte(strconv.Itoa(len(accounts))))
+	if err != nil {
+		return 0, err
+	}
+	return len(accounts), nil
+}
+
+func saPoolKey(name string, index int) string {
+	return fmt.Sprintf("%s%s:%d", SA_POOL, name, index)
+}
+
+// saPoolSize returns the number of accounts in the pool name, 0 if there's
+// no such pool.
+func saPoolSize(db *handle, p Profile, name string) (int, error) {
+	data, err := db.Get(p.key(SA_POOL + name))
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(data))
+}
+
+func deleteSAPool(db *handle, p Profile, name string) error {
+	size, err := saPoolSize(db, p, name)
+	if err != nil || size == 0 {
+		return err
+	}
+	for i := 0; i < size; i++ {
+		err = db.Delete(p.key(saPoolKey(name, i)))
+		if err != nil {
+			return err
+		}
+	}
+	return db.Delete(p.key(SA_POOL + name))
+}
+
+func GetSAPoolDb(p Profile, name string) ([]ServiceAccount, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	size, err := saPoolSize(db, p, name)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, ErrKeyNotFound
+	}
+	accounts := make([]ServiceAccount, size)
+	for i := range accounts {
+		data, err := db.Get(p.key(saPoolKey(name, i)))
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &accounts[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+func IsSAPoolInDb(p Profile, name string) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+	return db.Has(p.key(SA_POOL + name)), nil
+}
+
+func RemoveSAPoolDb(p Profile, name string) (bool, error) {
+	db, err := getDb(p.Path)
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
-	err := db.Delete([]byte(DL_DIR))
+	err = deleteSAPool(db, p, name)
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	PROFILES        string = "profiles"
+	PROFILE_DEFAULT string = "profile_default"
+	PROFILE         string = "profile:"
+)
+
+// DEFAULT_PROFILE always exists and keeps its settings under the plain keys,
+// so databases from before profiles keep working.
+const DEFAULT_PROFILE string = "default"
+
+// MAX_NAME_LEN caps profile and service-account pool names. Both end up in
+// the key of every account of a pool, which bitcask limits to 64 bytes:
+// "profile:" + profile + ":sapool:" + pool + ":" + index.
+const MAX_NAME_LEN int = 20
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validName checks name, a profile or pool name, for length and charset.
+func validName(kind string, name string) error {
+	if len(name) > MAX_NAME_LEN || !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid %s name %q, use up to %d letters, digits, '.', '_' or '-'", kind, name, MAX_NAME_LEN)
+	}
+	return nil
+}
+
+// Profile is where the credentials, tokens, service accounts and download
+// dir of one Google account are kept in the database at Path.
+type Profile struct {
+	Path string
+	Name string
+}
+
+// key returns key as stored for p.
+func (p Profile) key(key string) []byte {
+	if p.Name == "" || p.Name == DEFAULT_PROFILE {
+		return []byte(key)
+	}
+	return []byte(PROFILE + p.Name + ":" + key)
+}
+
+// GetProfilesDb returns the names of all profiles, the default one first.
+func GetProfilesDb(dbPath string) ([]string, error) {
+	db, err := getDb(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	names := []string{DEFAULT_PROFILE}
+	if !db.Has([]byte(PROFILES)) {
+		return names, nil
+	}
+	data, err := db.Get([]byte(PROFILES))
+	if err != nil {
+		return nil, err
+	}
+	var added []string
+	err = json.Unmarshal(data, &added)
+	if err != nil {
+		return nil, err
+	}
+	return append(names, added...), nil
+}
+
+func putProfilesDb(dbPath string, names []string) error {
+	// The default profile isn't stored, it's always there.
+	data, err := json.Marshal(names[1:])
+	if err != nil {
+		return err
+	}
+	db, err := getDb(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Put([]byte(PROFILES), data)
+}
+
+func IsProfileInDb(dbPath string, name string) (bool, error) {
+	names, err := GetProfilesDb(dbPath)
+	if err != nil {
+		return false, err
+	}
+	return indexOf(names, name) >= 0, nil
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func AddProfileDb(dbPath string, name string) (bool, error) {
+	err := validName("profile", name)
+	if err != nil {
+		return false, err
+	}
+	names, err := GetProfilesDb(dbPath)
+	if err != nil {
+		return false, err
+	}
+	if indexOf(names, name) >= 0 {
+		return false, nil
+	}
+	err = putProfilesDb(dbPath, append(names, name))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RemoveProfileDb removes name along with everything stored for it.
+func RemoveProfileDb(dbPath string, name string) (bool, error) {
+	if name == DEFAULT_PROFILE {
+		return false, errors.New("the default profile can't be removed")
+	}
+	names, err := GetProfilesDb(dbPath)
+	if err != nil {
+		return false, err
+	}
+	i := indexOf(names, name)
+	if i < 0 {
+		return false, nil
+	}
+	err = putProfilesDb(dbPath, append(names[:i], names[i+1:]...))
+	if err != nil {
+		return false, err
+	}
+	db, err := getDb(dbPath)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+	var keys [][]byte
+	err = db.Scan([]byte(PROFILE+name+":"), func(key []byte) error {
+		keys = append(keys, append([]byte(nil), key...))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		err = db.Delete(key)
+		if err != nil {
+			return false, err
+		}
+	}
+	data, err := db.Get([]byte(PROFILE_DEFAULT))
+	if err == nil && string(data) == name {
+		err = db.Delete([]byte(PROFILE_DEFAULT))
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// SetDefaultProfileDb makes name the profile used when --profile isn't given.
+func SetDefaultProfileDb(dbPath string, name string) error {
+	exists, err := IsProfileInDb(dbPath, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("no profile named %s", name)
+	}
+	db, err := getDb(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if name == DEFAULT_PROFILE {
+		if !db.Has([]byte(PROFILE_DEFAULT)) {
+			return nil
+		}
+		return db.Delete([]byte(PROFILE_DEFAULT))
+	}
+	return db.Put([]byte(PROFILE_DEFAULT), []byte(name))
+}
+
+func GetDefaultProfileDb(dbPath string) (string, error) {
+	db, err := getDb(dbPath)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	data, err := db.Get([]byte(PROFILE_DEFAULT))
+	if err == ErrKeyNotFound {
+		return DEFAULT_PROFILE, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
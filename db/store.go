@@ -0,0 +1,276 @@
+package db
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JOB        string = "job:"
+	JOB_FILE   string = "jobfile:"
+	HASH_CACHE string = "hash:"
+	SYNC       string = "sync:"
+	SYNC_NODE  string = "syncnode:"
+)
+
+// STORE_FLUSH_INTERVAL is how long writes to a Store are buffered at most
+// before they're written to the database.
+const STORE_FLUSH_INTERVAL time.Duration = time.Second
+
+// Store buffers the frequent journal and hash cache writes of a download and
+// writes them in batches, so that the database is only locked for a moment
+// at a time and other runs on the same database keep working.
+type Store struct {
+	path string
+	// pending holds writes not yet in the database, nil for deletes.
+	pending map[string][]byte
+	// cache holds every key below the preloaded prefixes.
+	cache     map[string][]byte
+	preloaded []string
+	done      chan struct{}
+	closed    sync.Once
+	mut       sync.Mutex
+}
+
+func OpenStore(dbPath string) (*Store, error) {
+	// Make sure the database can be opened at all before a job relies on it.
+	db, err := getDb(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Close()
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		path:    dbPath,
+		pending: make(map[string][]byte),
+		cache:   make(map[string][]byte),
+		done:    make(chan struct{}),
+	}
+	go s.flushLoop()
+	return s, nil
+}
+
+func (s *Store) flushLoop() {
+	ticker := time.NewTicker(STORE_FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mut.Lock()
+			err := s.flush()
+			s.mut.Unlock()
+			if err != nil {
+				log.Printf("[DatabaseError]: %v\n", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// flush writes pending to the database, s.mut has to be held.
+func (s *Store) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	return s.with(func(db *handle) error { return nil })
+}
+
+// with opens the database, writes what's pending and runs fn on it, s.mut
+// has to be held.
+func (s *Store) with(fn func(db *handle) error) error {
+	db, err := getDb(s.path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for key, data := range s.pending {
+		if data == nil {
+			err = db.Delete([]byte(key))
+		} else {
+			err = db.Put([]byte(key), data)
+		}
+		if err != nil {
+			return err
+		}
+		delete(s.pending, key)
+	}
+	return fn(db)
+}
+
+// Close writes what's still pending.
+func (s *Store) Close() error {
+	s.closed.Do(func() {
+		close(s.done)
+	})
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.flush()
+}
+
+// Preload reads every key starting with prefix at once, so that Gets below
+// it don't have to open the database.
+func (s *Store) Preload(prefix string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.with(func(db *handle) error {
+		err := db.Scan([]byte(prefix), func(key []byte) error {
+			data, err := db.Get(key)
+			if err != nil {
+				return err
+			}
+			s.cache[string(key)] = data
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.preloaded = append(s.preloaded, prefix)
+		return nil
+	})
+}
+
+func (s *Store) isPreloaded(key string) bool {
+	for _, prefix := range s.preloaded {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) Put(key string, data []byte) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.pending[key] = data
+	if s.isPreloaded(key) {
+		s.cache[key] = data
+	}
+	return nil
+}
+
+func (s *Store) Get(key string) ([]byte, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if data, ok := s.pending[key]; ok {
+		if data == nil {
+			return nil, ErrKeyNotFound
+		}
+		return data, nil
+	}
+	if s.isPreloaded(key) {
+		data, ok := s.cache[key]
+		if !ok {
+			return nil, ErrKeyNotFound
+		}
+		return data, nil
+	}
+	var data []byte
+	err := s.with(func(db *handle) error {
+		var err error
+		data, err = db.Get([]byte(key))
+		return err
+	})
+	return data, err
+}
+
+func (s *Store) Delete(key string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.pending[key] = nil
+	delete(s.cache, key)
+	return nil
+}
+
+// ScanValues returns the values of every key starting with prefix.
+func (s *Store) ScanValues(prefix string) ([][]byte, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	var values [][]byte
+	err := s.with(func(db *handle) error {
+		return db.Scan([]byte(prefix), func(key []byte) error {
+			data, err := db.Get(key)
+			if err != nil {
+				return err
+			}
+			values = append(values, data)
+			return nil
+		})
+	})
+	return values, err
+}
+
+// DeletePrefix removes every key starting with prefix.
+func (s *Store) DeletePrefix(prefix string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for key := range s.cache {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.cache, key)
+		}
+	}
+	return s.with(func(db *handle) error {
+		var keys [][]byte
+		err := db.Scan([]byte(prefix), func(key []byte) error {
+			keys = append(keys, append([]byte{}, key...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := db.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func JobKey(jobId string) string {
+	return JOB + jobId
+}
+
+func JobFilesPrefix(jobId string) string {
+	return fmt.Sprintf("%s%s:", JOB_FILE, jobId)
+}
+
+func JobFileKey(jobId string, index int) string {
+	return fmt.Sprintf("%s%d", JobFilesPrefix(jobId), index)
+}
+
+// HashCacheKey hashes filePath, keys are limited to 64 bytes and paths
+// easily exceed that.
+func HashCacheKey(filePath string) string {
+	sum := sha1.Sum([]byte(filePath))
+	return HASH_CACHE + hex.EncodeToString(sum[:])
+}
+
+func SyncKey(rootId string) string {
+	return SYNC + rootId
+}
+
+// SyncNodesPrefix shortens rootId to a hash so that node keys stay within
+// the key size limit.
+func SyncNodesPrefix(rootId string) string {
+	sum := sha1.Sum([]byte(rootId))
+	return fmt.Sprintf("%s%s:", SYNC_NODE, hex.EncodeToString(sum[:4]))
+}
+
+// SyncNodeKey keeps nodeKey as it is when it fits, the keys of nodes reached
+// through shortcuts are longer than a Drive id and get hashed.
+func SyncNodeKey(rootId string, nodeKey string) string {
+	key := SyncNodesPrefix(rootId) + nodeKey
+	if len(key) <= 64 {
+		return key
+	}
+	sum := sha1.Sum([]byte(nodeKey))
+	return SyncNodesPrefix(rootId) + hex.EncodeToString(sum[:])
+}
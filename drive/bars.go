@@ -0,0 +1,177 @@
+package drive
+
+import (
+	"drivedlgo/customdec"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+)
+
+// BarSink shows a progress bar for every transfer in the terminal, it is
+// what the command line uses. It is also an io.Writer so log output can be
+// printed above the bars.
+type BarSink struct {
+	w        io.Writer
+	progress *mpb.Progress
+	bars     map[string]*fileBar
+	mut      sync.Mutex
+}
+
+type fileBar struct {
+	*mpb.Bar
+	total int64
+}
+
+// NewBarSink returns a BarSink that prints its messages and the summary of
+// every job to w while no bars are shown.
+func NewBarSink(w io.Writer) *BarSink {
+	return &BarSink{w: w, bars: make(map[string]*fileBar)}
+}
+
+// container returns the running progress container, a new one is started
+// for every job.
+func (s *BarSink) container() *mpb.Progress {
+	if s.progress == nil {
+		s.progress = mpb.New(mpb.WithWidth(60), mpb.WithRefreshRate(180*time.Millisecond))
+	}
+	return s.progress
+}
+
+func (s *BarSink) Write(p []byte) (int, error) {
+	s.mut.Lock()
+	progress := s.progress
+	s.mut.Unlock()
+	if progress == nil {
+		return os.Stderr.Write(p)
+	}
+	return progress.Write(p)
+}
+
+// printf prints above the bars while they are shown, mpb would draw over
+// anything written to the terminal directly.
+func (s *BarSink) printf(format string, a ...interface{}) {
+	s.mut.Lock()
+	progress := s.progress
+	s.mut.Unlock()
+	if progress == nil {
+		fmt.Fprintf(s.w, format, a...)
+		return
+	}
+	fmt.Fprintf(progress, format, a...)
+}
+
+func prepareProgressBar(p *mpb.Progress, size int64, dec decor.Decorator) *mpb.Bar {
+	return p.AddBar(size,
+		mpb.PrependDecorators(
+			decor.Name("[ "),
+			dec,
+			decor.Name(" ] "),
+			decor.CountersKibiByte("% .2f / % .2f"),
+		),
+		mpb.AppendDecorators(
+			decor.AverageETA(decor.ET_STYLE_GO),
+			decor.Name("]"),
+			decor.AverageSpeed(decor.SizeB1000(0), " % .2f"),
+		),
+	)
+}
+
+func newProgressBar(p *mpb.Progress, filename string, size int64) *mpb.Bar {
+	if len(filename) > MAX_NAME_CHARACTERS {
+		marquee := customdec.NewChangeNameDecor(filename, MAX_NAME_CHARACTERS)
+		return prepareProgressBar(p, size, marquee.MarqueeText())
+	}
+	return prepareProgressBar(p, size, decor.Name(filename, decor.WC{W: 5, C: decor.DSyncSpaceR}))
+}
+
+// takeBar removes the bar of f from the map and returns it, nil if there is
+// none.
+func (s *BarSink) takeBar(f FileEvent) *fileBar {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	bar := s.bars[f.Path]
+	delete(s.bars, f.Path)
+	return bar
+}
+
+func (s *BarSink) FileQueued(f FileEvent) {}
+
+func (s *BarSink) FileStarted(f FileEvent) {
+	if old := s.takeBar(f); old != nil {
+		old.Abort(true)
+	}
+	s.mut.Lock()
+	total := f.Size
+	if total > 0 {
+		total -= f.Offset
+	}
+	s.bars[f.Path] = &fileBar{Bar: newProgressBar(s.container(), f.Name, total), total: total}
+	s.mut.Unlock()
+	if f.Offset != 0 {
+		s.printf("%s", color.GreenString(fmt.Sprintf("Resuming %s at offset %d\n", f.Name, f.Offset)))
+	}
+}
+
+func (s *BarSink) FileProgress(f FileEvent, n int64) {
+	s.mut.Lock()
+	bar := s.bars[f.Path]
+	s.mut.Unlock()
+	if bar != nil {
+		bar.IncrInt64(n)
+	}
+}
+
+func (s *BarSink) FileRetried(f FileEvent, attempt int, err error) {
+	log.Printf("err while downloading: retrying download (%d): %s: %v\n", attempt, f.Name, err)
+}
+
+func (s *BarSink) FileCompleted(f FileEvent) {
+	bar := s.takeBar(f)
+	if bar == nil {
+		return
+	}
+	if bar.total > 0 {
+		bar.SetCurrent(bar.total)
+	} else {
+		// Exports have no known size up front, so the total is set here.
+		bar.SetTotal(-1, true)
+	}
+}
+
+func (s *BarSink) FileFailed(f FileEvent, err error) {
+	if bar := s.takeBar(f); bar != nil {
+		bar.Abort(false)
+	}
+	log.Printf("[DownloadError]: %s: %v\n", f.Name, err)
+}
+
+func (s *BarSink) FileSkipped(f FileEvent, reason string) {
+	switch reason {
+	case SKIP_DOWNLOADED, SKIP_EXPORTED, SKIP_NO_EXPORT:
+		s.printf("%s %s.\n", f.Name, reason)
+	case PLAN_EXCLUDED:
+		s.printf("Skipping excluded folder %s.\n", f.Path)
+	case PLAN_SKIPPED:
+		s.printf("Skipping %s.\n", f.Name)
+	}
+}
+
+func (s *BarSink) JobFinished(result *Result) {
+	s.mut.Lock()
+	progress := s.progress
+	s.mut.Unlock()
+	if progress != nil {
+		progress.Wait()
+		s.mut.Lock()
+		s.progress = nil
+		s.mut.Unlock()
+	}
+	WriteSummary(s.w, result)
+}
@@ -0,0 +1,147 @@
+package drive
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const HASH_CHECKPOINT_INTERVAL int64 = 64 * 1024 * 1024
+
+const (
+	HASH_MD5    string = "md5"
+	HASH_SHA1   string = "sha1"
+	HASH_SHA256 string = "sha256"
+)
+
+// FileHasher hashes a download as it streams to disk, with every checksum
+// Drive has for the file. Its state can be saved in the part sidecar so a
+// resumed transfer continues hashing instead of re-reading the partial file.
+type FileHasher struct {
+	hashes          map[string]hash.Hash
+	expected        map[string]string
+	Offset          int64
+	Checkpoint      func()
+	sinceCheckpoint int64
+}
+
+func NewFileHasher(file *drive.File) *FileHasher {
+	h := &FileHasher{hashes: make(map[string]hash.Hash), expected: make(map[string]string)}
+	if file.Md5Checksum != "" {
+		h.hashes[HASH_MD5] = md5.New()
+		h.expected[HASH_MD5] = file.Md5Checksum
+	}
+	if file.Sha1Checksum != "" {
+		h.hashes[HASH_SHA1] = sha1.New()
+		h.expected[HASH_SHA1] = file.Sha1Checksum
+	}
+	if file.Sha256Checksum != "" {
+		h.hashes[HASH_SHA256] = sha256.New()
+		h.expected[HASH_SHA256] = file.Sha256Checksum
+	}
+	return h
+}
+
+func (h *FileHasher) Write(p []byte) (int, error) {
+	for _, hh := range h.hashes {
+		hh.Write(p)
+	}
+	h.Offset += int64(len(p))
+	h.sinceCheckpoint += int64(len(p))
+	if h.Checkpoint != nil && h.sinceCheckpoint >= HASH_CHECKPOINT_INTERVAL {
+		h.sinceCheckpoint = 0
+		h.Checkpoint()
+	}
+	return len(p), nil
+}
+
+// State returns the marshalled hash states, keyed by algorithm.
+func (h *FileHasher) State() map[string][]byte {
+	state := make(map[string][]byte)
+	for name, hh := range h.hashes {
+		data, err := hh.(encoding.BinaryMarshaler).MarshalBinary()
+		if err == nil {
+			state[name] = data
+		}
+	}
+	return state
+}
+
+// Restore loads a saved state that covers the first offset bytes.
+func (h *FileHasher) Restore(state map[string][]byte, offset int64) error {
+	for name, hh := range h.hashes {
+		data, ok := state[name]
+		if !ok {
+			return fmt.Errorf("no saved %s state", name)
+		}
+		err := hh.(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+		if err != nil {
+			return err
+		}
+	}
+	h.Offset = offset
+	return nil
+}
+
+// Rehash resets the hasher and feeds it the first offset bytes of filePath,
+// used when there is no saved state for the partial file.
+func (h *FileHasher) Rehash(filePath string, offset int64) error {
+	for _, hh := range h.hashes {
+		hh.Reset()
+	}
+	h.Offset = 0
+	return h.feed(filePath, offset)
+}
+
+// feed hashes filePath from the current offset up to offset.
+func (h *FileHasher) feed(filePath string, offset int64) error {
+	if h.Offset >= offset {
+		return nil
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Seek(h.Offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(h, f, offset-h.Offset)
+	return err
+}
+
+// Verify compares every computed checksum with the one Drive reported.
+func (h *FileHasher) Verify() error {
+	for name, hh := range h.hashes {
+		sum := hex.EncodeToString(hh.Sum(nil))
+		if sum != h.expected[name] {
+			return fmt.Errorf("%s mismatch: expected %s, got %s", name, h.expected[name], sum)
+		}
+	}
+	return nil
+}
+
+// syncHasher brings the hasher in line with the first offset bytes of the
+// partial file at filePath. A saved state is restored when there is one, so
+// only the bytes written after the last checkpoint are read back.
+func syncHasher(h *FileHasher, meta *PartMeta, filePath string, offset int64) error {
+	if h.Offset == offset {
+		return nil
+	}
+	if h.Offset < offset && h.Offset > 0 {
+		return h.feed(filePath, offset)
+	}
+	if meta != nil && meta.HashOffset > 0 && meta.HashOffset <= offset && h.Restore(meta.HashState, meta.HashOffset) == nil {
+		return h.feed(filePath, offset)
+	}
+	return h.Rehash(filePath, offset)
+}
@@ -0,0 +1,109 @@
+package drive
+
+import (
+	"drivedlgo/utils"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const DISK_CHECK_INTERVAL int64 = 64 * 1024 * 1024
+const DISK_WAIT_INTERVAL time.Duration = 30 * time.Second
+
+func (G *GoogleDriveClient) SetMinFreeSpace(minFree int64, force bool) {
+	G.minFree = minFree
+	G.forceSpace = force
+}
+
+// Preflight checks that the files left in job fit at the destination along
+// with the free space reserve, unless --force was given.
+func (G *GoogleDriveClient) Preflight(job *Job) error {
+	var remaining int64
+	for _, entry := range job.Unfinished() {
+		remaining += bytesLeft(entry)
+	}
+	free, err := utils.GetFreeSpace(job.LocalPath)
+	if err != nil {
+		log.Printf("[DiskSpaceError]: unable to check free space: %v\n", err)
+		return nil
+	}
+	G.printf("%s: %s to download, %s free\n", color.HiBlueString("Disk"), formatBytes(remaining), formatBytes(int64(free)))
+	if uint64(remaining+G.minFree) <= free {
+		return nil
+	}
+	if G.forceSpace {
+		G.printf("%s", color.YellowString("Not enough free space, continuing anyway because of --force.\n"))
+		return nil
+	}
+	return fmt.Errorf("%w at %s, need %s more, use --force to start anyway", ErrNotEnoughSpace, job.LocalPath, formatBytes(remaining+G.minFree-int64(free)))
+}
+
+// bytesLeft returns how much of entry still has to be fetched. A file that's
+// at its final path with the remote size already is most likely skipped, one
+// that differs is fetched in full next to it.
+func bytesLeft(entry *JobFile) int64 {
+	size, err := utils.GetFileSize(entry.Path)
+	if err == nil && size == entry.Size {
+		return 0
+	}
+	return entry.Size - partOffset(entry.DriveFile(), entry.Path)
+}
+
+// WaitForDiskSpace blocks while the free space at dirPath is below the
+// reserve, so workers pause instead of failing on write errors.
+func (R *jobRun) WaitForDiskSpace(dirPath string) {
+	if R.minFree <= 0 {
+		return
+	}
+	warned := false
+	for {
+		free, err := utils.GetFreeSpace(dirPath)
+		if err != nil || free >= uint64(R.minFree) {
+			if warned {
+				log.Printf("Free space at %s recovered, continuing.\n", dirPath)
+			}
+			return
+		}
+		if !warned {
+			log.Printf("[DiskSpaceWarning]: only %s free at %s, pausing until at least %s is available\n", formatBytes(int64(free)), dirPath, formatBytes(R.minFree))
+			warned = true
+		}
+		if !R.sleep(DISK_WAIT_INTERVAL) {
+			return
+		}
+	}
+}
+
+// diskGuardWriter checks free space every DISK_CHECK_INTERVAL bytes.
+type diskGuardWriter struct {
+	io.Writer
+	R       *jobRun
+	dirPath string
+	written int64
+	mut     sync.Mutex
+}
+
+func (w *diskGuardWriter) Write(p []byte) (int, error) {
+	w.mut.Lock()
+	w.written += int64(len(p))
+	check := w.written >= DISK_CHECK_INTERVAL
+	if check {
+		w.written = 0
+	}
+	w.mut.Unlock()
+	if check {
+		w.R.WaitForDiskSpace(w.dirPath)
+	}
+	return w.Writer.Write(p)
+}
+
+func (R *jobRun) guardWriter(writer io.Writer, dirPath string) io.Writer {
+	if R.minFree <= 0 {
+		return writer
+	}
+	return &diskGuardWriter{Writer: writer, R: R, dirPath: dirPath}
+}
@@ -1,7 +1,7 @@
 package drive
 
 import (
-	"drivedlgo/customdec"
+	"context"
 	"drivedlgo/db"
 	"drivedlgo/utils"
 	"fmt"
@@ -15,92 +15,122 @@ import (
 	"time"
 
 	"github.com/fatih/color"
-	"github.com/vbauerster/mpb/v8"
-
-	"github.com/vbauerster/mpb/v8/decor"
-	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
-	"google.golang.org/api/option"
+	"google.golang.org/api/googleapi"
 )
 
-var wg sync.WaitGroup
-
 const MAX_NAME_CHARACTERS int = 17
 const MAX_RETRIES int = 5
+const FILE_FIELDS string = "id,name,size,mimeType,md5Checksum,sha1Checksum,sha256Checksum,shortcutDetails,modifiedTime,createdTime,owners(emailAddress,displayName)"
 
+// GoogleDriveClient holds the account and settings downloads use. Every
+// Download, Resume, RunJob, DryRun, Mirror, Sync and List call keeps its own
+// state in a jobRun, so one authorized client can run several at once.
 type GoogleDriveClient struct {
 	GDRIVE_DIR_MIMETYPE string
 	TokenFile           string
 	CredentialFile      string
 	DriveSrv            *drive.Service
-	Progress            *mpb.Progress
+	httpClient          *http.Client
+	events              EventSink
+	saPool              string
+	accounts            []db.ServiceAccount
+	account             int
+	accountMut          sync.RWMutex
+	retry               RetryPolicy
+	out                 io.Writer
 	abuse               bool
-	numFilesDownloaded  int
-	channel             chan int
+	lastStats           *jobStats
+	statsMut            sync.Mutex
+	segments            int
+	rateLimiter         *RateLimiter
+	fileRateLimit       int64
+	exports             map[string]string
+	shortcutMode        string
+	filter              *Filter
+	minFree             int64
+	forceSpace          bool
+	preserveTimes       bool
+	preserveCreated     bool
+	checkStrategy       string
+	dbPath              string
+	profile             string
+	concurrency         int
 }
 
 func (G *GoogleDriveClient) Init() {
 	G.GDRIVE_DIR_MIMETYPE = "application/vnd.google-apps.folder"
 	G.TokenFile = "token.json"
 	G.CredentialFile = "credentials.json"
-	G.channel = make(chan int, 2)
-	G.Progress = mpb.New(mpb.WithWidth(60), mpb.WithRefreshRate(180*time.Millisecond))
+	G.concurrency = 2
+	G.retry = DefaultRetryPolicy()
+	G.segments = 1
+	G.SetExportFormats("")
+	G.SetShortcutMode(SHORTCUT_FOLLOW)
+	G.preserveTimes = true
+	G.checkStrategy = CHECK_MD5
+	G.events = NewBarSink(os.Stdout)
+	G.out = os.Stdout
 }
 
-func (G *GoogleDriveClient) SetAbusiveFileDownload(abuse bool) {
-	fmt.Printf("Acknowledge-Abuse: %t\n", abuse)
-	G.abuse = abuse
+// SetOutput sets where informational messages go, progress and results are
+// reported through the EventSink instead.
+func (G *GoogleDriveClient) SetOutput(w io.Writer) {
+	G.out = w
 }
 
-func (G *GoogleDriveClient) SetConcurrency(count int) {
-	fmt.Printf("Using Concurrency: %d\n", count)
-	G.channel = make(chan int, count)
+func (G *GoogleDriveClient) printf(format string, a ...interface{}) {
+	fmt.Fprintf(G.out, format, a...)
+}
+
+func (G *GoogleDriveClient) println(a ...interface{}) {
+	fmt.Fprintln(G.out, a...)
 }
 
-func (G *GoogleDriveClient) PrepareProgressBar(size int64, dec decor.Decorator) *mpb.Bar {
-	return G.Progress.AddBar(size,
-		mpb.PrependDecorators(
-			decor.Name("[ "),
-			dec,
-			decor.Name(" ] "),
-			decor.CountersKibiByte("% .2f / % .2f"),
-		),
-		mpb.AppendDecorators(
-			decor.AverageETA(decor.ET_STYLE_GO),
-			decor.Name("]"),
-			decor.AverageSpeed(decor.SizeB1000(0), " % .2f"),
-		),
-	)
+func (G *GoogleDriveClient) SetAbusiveFileDownload(abuse bool) {
+	G.printf("Acknowledge-Abuse: %t\n", abuse)
+	G.abuse = abuse
 }
 
-func (G *GoogleDriveClient) GetProgressBar(filename string, size int64) *mpb.Bar {
-	if len(filename) > MAX_NAME_CHARACTERS {
-		marquee := customdec.NewChangeNameDecor(filename, MAX_NAME_CHARACTERS)
-		return G.PrepareProgressBar(size, marquee.MarqueeText())
+func (G *GoogleDriveClient) SetConcurrency(count int) {
+	if count < 1 {
+		count = 1
 	}
-	return G.PrepareProgressBar(size, decor.Name(filename, decor.WC{W: 5, C: decor.DSyncSpaceR}))
+	G.printf("Using Concurrency: %d\n", count)
+	G.concurrency = count
 }
 
-func (G *GoogleDriveClient) getClient(dbPath string, config *oauth2.Config, port int) *http.Client {
-	tokBytes, err := db.GetTokenDb(dbPath)
+func (G *GoogleDriveClient) getClient(profile db.Profile, config *oauth2.Config, port int) (*http.Client, error) {
+	tokBytes, err := db.GetTokenDb(profile)
 	var tok *oauth2.Token
+	if err != nil && err != db.ErrKeyNotFound {
+		return nil, err
+	}
 	if err != nil {
-		tok = G.getTokenFromWeb(config, port)
-		db.AddTokenDb(dbPath, utils.OauthTokenToBytes(tok))
+		tok, err = G.getTokenFromWeb(config, port)
+		if err != nil {
+			return nil, err
+		}
+		_, err = db.AddTokenDb(profile, utils.OauthTokenToBytes(tok))
+		if err != nil {
+			log.Printf("[TokenError]: unable to save token, it will be asked for again next time: %v\n", err)
+		}
 	} else {
 		tok = utils.BytesToOauthToken(tokBytes)
 	}
-	return config.Client(context.Background(), tok)
+	return config.Client(context.Background(), tok), nil
 }
 
 func (G *GoogleDriveClient) getTokenFromHTTP(port int) (string, error) {
-	srv := &http.Server{Addr: fmt.Sprintf(":%d", port)}
+	// A mux of its own, the default one can only register "/" once per process.
+	mux := http.NewServeMux()
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
 	var code string
 	var codeReceived chan struct{} = make(chan struct{})
 	var err error
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		code = r.URL.Query().Get("code")
 		_, err = fmt.Fprint(w, "Code received, you can close this browser window now.")
 		codeReceived <- struct{}{}
@@ -116,72 +146,92 @@ func (G *GoogleDriveClient) getTokenFromHTTP(port int) (string, error) {
 	return code, err
 }
 
-func (G *GoogleDriveClient) getTokenFromWeb(config *oauth2.Config, port int) *oauth2.Token {
+func (G *GoogleDriveClient) getTokenFromWeb(config *oauth2.Config, port int) (*oauth2.Token, error) {
 	config.RedirectURL = fmt.Sprintf("http://localhost:%d", port)
 	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser: \n%v\n", authURL)
+	G.printf("Go to the following link in your browser: \n%v\n", authURL)
 	err := utils.OpenBrowserURL(authURL)
 	if err != nil {
 		log.Printf("unable to open browser, you have to manually visit the provided link: %v\n", err)
 	}
 	authCode, err := G.getTokenFromHTTP(port)
 	if err != nil && err != http.ErrServerClosed {
-		log.Fatalf("unable to get token from oauth web: %v\n", err)
+		return nil, fmt.Errorf("unable to get token from oauth web: %v", err)
 	}
 	tok, err := config.Exchange(context.TODO(), authCode)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web %v", err)
+		return nil, fmt.Errorf("unable to retrieve token from web: %v", err)
+	}
+	return tok, nil
+}
+
+// SetProfile picks the profile Authorize takes credentials, tokens and
+// service accounts from, the default one if empty.
+func (G *GoogleDriveClient) SetProfile(name string) {
+	if name != "" && name != db.DEFAULT_PROFILE {
+		G.printf("Using Profile: %s\n", name)
 	}
-	return tok
+	G.profile = name
 }
 
-func (G *GoogleDriveClient) Authorize(dbPath string, useSA bool, port int) {
+func (G *GoogleDriveClient) Authorize(dbPath string, useSA bool, port int) error {
 	var client *http.Client
+	var err error
+	G.dbPath = dbPath
+	profile := db.Profile{Path: dbPath, Name: G.profile}
 	if useSA {
-		fmt.Println("Authorizing via service-account")
-		jwtConfigJsonBytes, err := db.GetJWTConfigDb(dbPath)
+		G.println("Authorizing via service-account")
+		client, err = G.authorizeServiceAccounts(profile)
 		if err != nil {
-			log.Fatalf("Unable to Get SA Credentials from Db, make sure to use setsa command: %v", err)
+			return fmt.Errorf("%w: %v", ErrAuth, err)
 		}
-		// If modifying these scopes, delete your previously saved token.json.
-		config, err := google.JWTConfigFromJSON(jwtConfigJsonBytes, drive.DriveScope)
-		if err != nil {
-			log.Fatalf("Unable to parse client secret file to config: %v", err)
-		}
-		client = config.Client(context.Background())
 	} else {
-		fmt.Println("Authorizing via google-account")
-		credsJsonBytes, err := db.GetCredentialsDb(dbPath)
+		G.println("Authorizing via google-account")
+		credsJsonBytes, err := db.GetCredentialsDb(profile)
+		if err != nil && err != db.ErrKeyNotFound {
+			return fmt.Errorf("%w: %v", ErrAuth, err)
+		}
 		if err != nil {
-			log.Fatalf("Unable to Get Credentials from Db, make sure to use set command: %v", err)
+			return fmt.Errorf("%w: unable to get credentials from db, make sure to use set command: %v", ErrAuth, err)
 		}
 
 		// If modifying these scopes, delete your previously saved token.json.
 		config, err := google.ConfigFromJSON(credsJsonBytes, drive.DriveScope)
 		if err != nil {
-			log.Fatalf("Unable to parse client secret file to config: %v", err)
+			return fmt.Errorf("%w: unable to parse client secret file to config: %v", ErrAuth, err)
+		}
+		client, err = G.getClient(profile, config, port)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrAuth, err)
 		}
-		client = G.getClient(dbPath, config, port)
 	}
-	srv, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
+	err = G.useClient(client)
 	if err != nil {
-		log.Fatalf("Unable to retrieve Drive client: %v", err)
+		return fmt.Errorf("%w: unable to retrieve Drive client: %v", ErrAuth, err)
 	}
-	G.DriveSrv = srv
+	return nil
 }
 
-func (G *GoogleDriveClient) GetFilesByParentId(parentId string) []*drive.File {
+func (R *jobRun) GetFilesByParentId(parentId string) []*drive.File {
 	var files []*drive.File
 	pageToken := ""
 	for {
-		request := G.DriveSrv.Files.List().Q("'" + parentId + "' in parents and trashed=false").OrderBy("name,folder").SupportsAllDrives(true).IncludeTeamDriveItems(true).PageSize(1000).
-			Fields("nextPageToken,files(id, name,size, mimeType,md5Checksum)")
+		request := R.srv().Files.List().Q("'" + parentId + "' in parents and trashed=false").OrderBy("name,folder").SupportsAllDrives(true).IncludeTeamDriveItems(true).PageSize(1000).
+			Fields(googleapi.Field("nextPageToken,files(" + FILE_FIELDS + ")")).Context(R.ctx)
 		if pageToken != "" {
 			request = request.PageToken(pageToken)
 		}
 		res, err := request.Do()
+		for attempt := 1; err != nil; attempt++ {
+			delay, retry := R.retryDelay(attempt, err)
+			if !retry || !R.sleep(delay) {
+				break
+			}
+			res, err = request.Do()
+		}
 		if err != nil {
-			fmt.Printf("Error : %v", err)
+			R.printf("Error : %v\n", err)
+			R.listErrors += 1
 			return files
 		}
 		files = append(files, res.Files...)
@@ -193,132 +243,602 @@ func (G *GoogleDriveClient) GetFilesByParentId(parentId string) []*drive.File {
 	return files
 }
 
-func (G *GoogleDriveClient) GetFileMetadata(fileId string) *drive.File {
-	file, err := G.DriveSrv.Files.Get(fileId).Fields("name,mimeType,size,id,md5Checksum").SupportsAllDrives(true).Do()
+func (R *jobRun) getFile(fileId string) (*drive.File, error) {
+	return R.srv().Files.Get(fileId).Fields(googleapi.Field(FILE_FIELDS)).SupportsAllDrives(true).Context(R.ctx).Do()
+}
+
+func (G *GoogleDriveClient) GetFileMetadata(fileId string) (*drive.File, error) {
+	return G.newRun(context.Background()).getFile(fileId)
+}
+
+// sleep waits for d unless the operation gets cancelled first, in which case
+// it returns false.
+func (R *jobRun) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-R.ctx.Done():
+		return false
+	}
+}
+
+// openStore opens the job store unless a caller further up has it open
+// already. The returned func writes out and closes it again if it was opened
+// here.
+func (R *jobRun) openStore() (func(), error) {
+	if R.store != nil {
+		return func() {}, nil
+	}
+	store, err := db.OpenStore(R.dbPath)
+	if err != nil {
+		return nil, err
+	}
+	// Existing files are looked up one by one, read the hash cache in one go.
+	err = store.Preload(db.HASH_CACHE)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	R.store = store
+	return func() {
+		err := store.Close()
+		if err != nil {
+			log.Printf("[DatabaseError]: %v\n", err)
+		}
+		R.store = nil
+	}, nil
+}
+
+// Download fetches nodeId, a file or a folder, into dest. It returns once
+// every file is done, failed or ctx is cancelled. A job that didn't finish
+// can be continued with Resume using Result.JobId.
+func (G *GoogleDriveClient) Download(ctx context.Context, nodeId string, dest string, opts Options) (*Result, error) {
+	return G.newRun(ctx).download(nodeId, dest, opts)
+}
+
+func (R *jobRun) download(nodeId string, dest string, opts Options) (*Result, error) {
+	closeStore, err := R.openStore()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(0)
+		return nil, fmt.Errorf("unable to open database: %v", err)
 	}
-	return file
+	defer closeStore()
+	job, err := NewJob(R.store, nodeId, dest, opts.Output)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create job journal: %v", err)
+	}
+	return R.runJob(job)
 }
 
-func (G *GoogleDriveClient) Download(nodeId string, localPath string, outputPath string) {
+// Resume continues the job jobId that an earlier Download left unfinished.
+func (G *GoogleDriveClient) Resume(ctx context.Context, jobId string) (*Result, error) {
+	run := G.newRun(ctx)
+	closeStore, err := run.openStore()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database: %v", err)
+	}
+	defer closeStore()
+	job, err := LoadJob(run.store, jobId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load job %s from database: %v", jobId, err)
+	}
+	return run.runJob(job)
+}
+
+// RunJob resolves job if it hasn't been yet and downloads what is left of it.
+func (G *GoogleDriveClient) RunJob(ctx context.Context, job *Job) (*Result, error) {
+	run := G.newRun(ctx)
+	closeStore, err := run.openStore()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database: %v", err)
+	}
+	defer closeStore()
+	return run.runJob(job)
+}
+
+func (R *jobRun) runJob(job *Job) (*Result, error) {
 	startTime := time.Now()
-	file := G.GetFileMetadata(nodeId)
+	R.job = job
+	R.stats.reset()
+	R.printf("%s: %s\n", color.HiBlueString("Job-Id"), color.HiGreenString(job.Id))
+	if !job.Resolved {
+		// Resolve the whole tree into the journal first, so the preflight
+		// check knows how much is left to download.
+		R.listErrors = 0
+		err := R.Walk(job.NodeId, job.LocalPath, job.OutputPath)
+		if err != nil {
+			if len(job.Files()) == 0 {
+				// Nothing to resume, don't leave an empty journal behind.
+				job.Remove()
+			}
+			return nil, err
+		}
+		if R.listErrors == 0 {
+			err = job.SetResolved()
+			if err != nil {
+				log.Printf("[JournalError]: %v\n", err)
+			}
+		}
+	}
+	err := R.Preflight(job)
+	if err != nil {
+		return R.newResult(job, startTime), err
+	}
+	R.DownloadJournal(job)
+	R.ApplyFolderTimes(job)
+	result := R.newResult(job, startTime)
+	if result.Complete {
+		err = job.Remove()
+		if err != nil {
+			log.Printf("[JournalError]: %v\n", err)
+		}
+	}
+	R.events.JobFinished(result)
+	if R.ctx.Err() != nil {
+		return result, R.ctx.Err()
+	}
+	if !result.Complete {
+		return result, fmt.Errorf("%w: %d files left", ErrIncomplete, len(result.Failed()))
+	}
+	return result, nil
+}
+
+// DownloadJournal downloads every unfinished file of job on the client's
+// worker pool and returns once all of them are done.
+func (R *jobRun) DownloadJournal(job *Job) {
+	files := job.Unfinished()
+	R.printf("%s: %d files left in journal\n", color.HiBlueString("Queue"), len(files))
+	pool := newWorkerPool(R.concurrency)
+	defer pool.Close()
+	for _, entry := range files {
+		if R.ctx.Err() != nil {
+			break
+		}
+		err := os.MkdirAll(path.Dir(entry.Path), 0755)
+		if err != nil {
+			log.Printf("[DirectoryCreationError]: %v\n", err)
+			continue
+		}
+		file, absPath := entry.DriveFile(), entry.Path
+		R.events.FileQueued(newFileEvent(file, absPath))
+		pool.Submit(func() {
+			R.HandleDownloadFile(file, absPath)
+		})
+	}
+}
+
+// Walk resolves nodeId into the journal, or the plan on a dry run. Only a
+// failure to get nodeId itself is returned, errors further down are counted
+// in listErrors.
+func (R *jobRun) Walk(nodeId string, localPath string, outputPath string) error {
+	// Linked shortcuts can point to anything in the tree, so they're made
+	// once it's all there.
+	defer R.writeLinks()
+	file, err := R.getFile(nodeId)
+	if err != nil {
+		return fmt.Errorf("unable to get %s: %w", nodeId, err)
+	}
 	if outputPath == "" {
 		outputPath = utils.CleanupFilename(file.Name)
 	}
-	fmt.Printf("%s(%s): %s -> %s/%s\n", color.HiBlueString("Download"), color.GreenString(file.MimeType), color.HiGreenString(file.Id), color.HiYellowString(localPath), color.HiYellowString(outputPath))
+	R.printf("%s(%s): %s -> %s/%s\n", color.HiBlueString("Download"), color.GreenString(file.MimeType), color.HiGreenString(file.Id), color.HiYellowString(localPath), color.HiYellowString(outputPath))
 	absPath := path.Join(localPath, outputPath)
-	if file.MimeType == G.GDRIVE_DIR_MIMETYPE {
-		err := os.MkdirAll(absPath, 0755)
-		if err != nil {
-			fmt.Println("Error while creating directory: ", err.Error())
-			return
+	R.rootPath = absPath
+	indexId := file.Id
+	if file.MimeType == GDRIVE_SHORTCUT_MIMETYPE {
+		target, ok := R.HandleShortcut(file, absPath)
+		if !ok {
+			return nil
 		}
-		files := G.GetFilesByParentId(file.Id)
+		file = target
+	}
+	if file.MimeType == R.GDRIVE_DIR_MIMETYPE {
+		if !R.makeDir(file, absPath, "", indexId) {
+			R.listErrors += 1
+			return nil
+		}
+		files := R.GetFilesByParentId(file.Id)
 		if len(files) == 0 {
-			fmt.Println("google drive folder is empty.")
+			R.println("google drive folder is empty.")
 		} else {
-			G.TraverseNodes(file.Id, absPath)
+			R.TraverseNodes(file.Id, absPath, childScope("", indexId, file.Id))
 		}
 	} else {
-		err := os.MkdirAll(localPath, 0755)
-		if err != nil {
-			fmt.Println("Error while creating directory: ", err.Error())
-			return
+		if !R.dryRun {
+			err := os.MkdirAll(localPath, 0755)
+			if err != nil {
+				return fmt.Errorf("unable to create directory: %v", err)
+			}
+		}
+		R.queueFile(file, absPath, "", indexId)
+	}
+	return nil
+}
+
+// makeDir creates the local folder for a Drive folder, or records it in the
+// plan during a dry run. scope and indexId are what a sync keeps it under,
+// see SyncNode.
+func (R *jobRun) makeDir(file *drive.File, absPath string, scope string, indexId string) bool {
+	R.markRemote(absPath)
+	R.indexNode(scope, indexId, file, absPath, true)
+	R.localPaths[file.Id] = absPath
+	if R.dryRun {
+		R.plan.Add(&PlanEntry{Path: R.relativePath(absPath), Id: file.Id, Folder: true, Action: PLAN_FOLDER})
+		return true
+	}
+	err := os.MkdirAll(absPath, 0755)
+	if err != nil {
+		log.Printf("[DirectoryCreationError]: %v\n", err)
+		return false
+	}
+	_, err = R.job.TrackFolder(file, absPath)
+	if err != nil {
+		log.Printf("[JournalError]: %v\n", err)
+	}
+	return true
+}
+
+// queueFile records file in the job journal, DownloadJournal picks it up
+// once traversal is done. scope and indexId are what a sync keeps it under,
+// see SyncNode.
+func (R *jobRun) queueFile(file *drive.File, absPath string, scope string, indexId string) {
+	if IsWorkspaceFile(file) {
+		if ext, _, ok := R.exportFormat(file); ok {
+			absPath = exportPath(absPath, ext)
 		}
-		G.channel <- 1
-		wg.Add(1)
-		go G.HandleDownloadFile(file, absPath)
 	}
-	wg.Wait()
-	G.Progress.Wait()
-	fmt.Printf("%s", color.GreenString(fmt.Sprintf("Downloaded %d files in %s.\n", G.numFilesDownloaded, time.Now().Sub(startTime))))
+	R.markRemote(absPath)
+	R.indexNode(scope, indexId, file, absPath, false)
+	R.localPaths[file.Id] = absPath
+	if R.dryRun {
+		R.plan.Add(R.PlanFile(file, absPath))
+		return
+	}
+	_, err := R.job.Track(file, absPath)
+	if err != nil {
+		log.Printf("[JournalError]: %v\n", err)
+	}
+}
+
+func (R *jobRun) skipNode(file *drive.File, absPath string, reason string) {
+	// Whatever was skipped on purpose is left alone by mirror.
+	if reason == PLAN_EXCLUDED {
+		R.protectRemote(absPath)
+	} else {
+		R.markRemote(absPath)
+	}
+	if R.dryRun {
+		folder := file.MimeType == R.GDRIVE_DIR_MIMETYPE
+		R.plan.Add(&PlanEntry{Path: R.relativePath(absPath), Id: file.Id, Folder: folder, Size: file.Size, Action: reason})
+		return
+	}
+	R.events.FileSkipped(newFileEvent(file, absPath), reason)
 }
 
-func (G *GoogleDriveClient) TraverseNodes(nodeId string, localPath string) {
-	files := G.GetFilesByParentId(nodeId)
+// TraverseNodes walks the children of nodeId into localPath. scope is
+// where a sync indexes them, see SyncNode.
+func (R *jobRun) TraverseNodes(nodeId string, localPath string, scope string) {
+	R.walking[nodeId] = true
+	defer delete(R.walking, nodeId)
+	files := R.GetFilesByParentId(nodeId)
 	for _, file := range files {
+		if R.ctx.Err() != nil {
+			// Leaves the job unresolved, so resume walks again.
+			R.listErrors += 1
+			return
+		}
 		absPath := path.Join(localPath, utils.CleanupFilename(file.Name))
-		if file.MimeType == G.GDRIVE_DIR_MIMETYPE {
-			err := os.MkdirAll(absPath, 0755)
-			if err != nil {
-				log.Printf("[DirectoryCreationError]: %v\n", err)
+		indexId := file.Id
+		if file.MimeType == GDRIVE_SHORTCUT_MIMETYPE {
+			target, ok := R.HandleShortcut(file, absPath)
+			if !ok {
 				continue
 			}
-			G.TraverseNodes(file.Id, absPath)
+			file = target
+		}
+		relPath := R.relativePath(absPath)
+		if file.MimeType == R.GDRIVE_DIR_MIMETYPE {
+			if !R.filter.MatchDir(relPath) {
+				R.skipNode(file, absPath, PLAN_EXCLUDED)
+				continue
+			}
+			if !R.makeDir(file, absPath, scope, indexId) {
+				R.listErrors += 1
+				continue
+			}
+			R.TraverseNodes(file.Id, absPath, childScope(scope, indexId, file.Id))
+		} else if R.filter.MatchFile(relPath) && R.filter.MatchMetadata(file) {
+			R.queueFile(file, absPath, scope, indexId)
 		} else {
-			G.channel <- 1
-			wg.Add(1)
-			go G.HandleDownloadFile(file, absPath)
+			R.skipNode(file, absPath, PLAN_FILTERED)
 		}
 	}
 }
 
-func (G *GoogleDriveClient) HandleDownloadFile(file *drive.File, absPath string) {
-	defer func() {
-		wg.Done()
-		<-G.channel
-	}()
+func (G *GoogleDriveClient) SetFilter(filter *Filter) {
+	G.filter = filter
+}
 
-	exists, bytesDled, err := utils.CheckLocalFile(absPath, file.Md5Checksum)
+// relativePath returns absPath relative to the folder being downloaded,
+// which is what filters match against.
+func (R *jobRun) relativePath(absPath string) string {
+	if absPath == R.rootPath {
+		return path.Base(absPath)
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(absPath, R.rootPath), "/")
+}
+
+// CheckLocalFile reports whether file is already complete at absPath and
+// otherwise the offset its .part file can be resumed from. entry may be nil
+// when there is no journal for the file.
+func (R *jobRun) CheckLocalFile(file *drive.File, absPath string, entry *JobFile) (bool, int64, error) {
+	if entry == nil || entry.State == JOB_FILE_PENDING {
+		exists, err := R.isComplete(file, absPath)
+		if err != nil || exists {
+			return exists, file.Size, err
+		}
+	}
+	// The journal already knows this file is incomplete, no need to hash it.
+	return false, partOffset(file, absPath), nil
+}
+
+func (R *jobRun) HandleDownloadFile(file *drive.File, absPath string) {
+	if IsWorkspaceFile(file) {
+		R.HandleExportFile(file, absPath)
+		return
+	}
+	event := newFileEvent(file, absPath)
+	entry, err := R.job.Track(file, absPath)
 	if err != nil {
-		log.Printf("[FileCheckError]: %v\n", err)
+		log.Printf("[JournalError]: %v\n", err)
+	}
+	if entry.State == JOB_FILE_DONE {
+		R.stats.addSkipped()
+		R.events.FileSkipped(event, SKIP_DOWNLOADED)
+		return
+	}
+	exists, bytesDled, err := R.CheckLocalFile(file, absPath, entry)
+	if err != nil {
+		R.failFile(entry, event, 0, err)
 		return
 	}
 	if exists {
-		fmt.Printf("%s already downloaded.\n", file.Name)
+		R.stats.addSkipped()
+		R.events.FileSkipped(event, SKIP_DOWNLOADED)
+		R.ApplyTimes(file, absPath)
+		R.setJobState(entry, JOB_FILE_DONE, file.Size)
+		return
+	}
+	R.WaitForDiskSpace(path.Dir(absPath))
+	hasher := NewFileHasher(file)
+	hasher.Checkpoint = func() {
+		err := writePartMeta(absPath, file, hasher, nil)
+		if err != nil {
+			log.Printf("[PartFileError]: %v\n", err)
+		}
+	}
+	bytesDled, err = preparePart(file, absPath, bytesDled, hasher, R.segments > 1)
+	if err != nil {
+		R.failFile(entry, event, 0, err)
+		return
+	}
+	if bytesDled > 0 {
+		R.stats.addResumed()
+	}
+	R.setJobState(entry, JOB_FILE_PARTIAL, bytesDled)
+	account := R.currentAccount()
+	err = R.transferFile(file, absPath, bytesDled, hasher)
+	for retry := 1; err != nil && R.rotateAccount(account, err); retry++ {
+		// The next account continues from what the last one left behind.
+		R.events.FileRetried(event, retry, err)
+		account = R.currentAccount()
+		bytesDled, err = preparePart(file, absPath, partOffset(file, absPath), hasher, R.segments > 1)
+		if err == nil {
+			err = R.transferFile(file, absPath, bytesDled, hasher)
+		}
+	}
+	if err == nil {
+		err = finishPart(file, absPath, hasher)
+	}
+	if err != nil {
+		R.failFile(entry, event, partOffset(file, absPath), err)
 		return
 	}
-	if bytesDled != 0 {
-		o := fmt.Sprintf("Resuming %s at offset %d\n", file.Name, bytesDled)
-		fmt.Printf("%s", color.GreenString(o))
+	R.stats.addDownloaded()
+	R.ApplyTimes(file, absPath)
+	R.rememberMd5(file, absPath)
+	R.setJobState(entry, JOB_FILE_DONE, file.Size)
+	R.events.FileCompleted(event)
+}
+
+// transferFile fetches file into the .part file of absPath, starting at
+// bytesDled.
+func (R *jobRun) transferFile(file *drive.File, absPath string, bytesDled int64, hasher *FileHasher) error {
+	if file.Size > 0 && bytesDled == file.Size {
+		// The transfer finished last time but never got moved into place.
+		return nil
 	}
-	G.DownloadFile(file, absPath, bytesDled, 1)
+	if R.canSegment(file, absPath, bytesDled) {
+		return R.DownloadFileSegmented(file, absPath, hasher)
+	}
+	err := R.DownloadFile(file, absPath, bytesDled, hasher)
+	hasher.Checkpoint()
+	return err
 }
 
-func (G *GoogleDriveClient) DownloadFile(file *drive.File, localPath string, startByteIndex int64, retry int) bool {
-	writer, err := os.OpenFile(localPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-	defer writer.Close()
+func (R *jobRun) HandleExportFile(file *drive.File, absPath string) {
+	ext, mimeType, ok := R.exportFormat(file)
+	if !ok {
+		// Forms, Sites and the like have no export format, they are
+		// reported as skipped rather than left out of the job.
+		entry, err := R.job.Track(file, absPath)
+		if err != nil {
+			log.Printf("[JournalError]: %v\n", err)
+		}
+		R.stats.addSkipped()
+		err = R.job.SetSkipped(entry, SKIP_NO_EXPORT)
+		if err != nil {
+			log.Printf("[JournalError]: %v\n", err)
+		}
+		R.events.FileSkipped(newFileEvent(file, absPath), SKIP_NO_EXPORT)
+		return
+	}
+	absPath = exportPath(absPath, ext)
+	event := newFileEvent(file, absPath)
+	entry, err := R.job.Track(file, absPath)
 	if err != nil {
-		log.Printf("[FileOpenError]: %v\n", err)
-		return false
+		log.Printf("[JournalError]: %v\n", err)
 	}
-	writer.Seek(startByteIndex, 0)
-	request := G.DriveSrv.Files.Get(file.Id).AcknowledgeAbuse(G.abuse).SupportsAllDrives(true)
-	request.Header().Add("Range", fmt.Sprintf("bytes=%d-%d", startByteIndex, file.Size))
-	response, err := request.Download()
+	size, current := isExportCurrent(file, absPath)
+	if entry.State == JOB_FILE_DONE || current {
+		R.stats.addSkipped()
+		R.events.FileSkipped(event, SKIP_EXPORTED)
+		R.setJobState(entry, JOB_FILE_DONE, size)
+		return
+	}
+	R.setJobState(entry, JOB_FILE_PARTIAL, 0)
+	account := R.currentAccount()
+	err = R.retryExport(file, absPath, mimeType, event)
+	for retry := 1; err != nil && R.rotateAccount(account, err); retry++ {
+		R.events.FileRetried(event, retry, err)
+		account = R.currentAccount()
+		err = R.retryExport(file, absPath, mimeType, event)
+	}
+	if err == nil {
+		err = os.Rename(partPath(absPath), absPath)
+	}
+	if err != nil {
+		os.Remove(partPath(absPath))
+		R.failFile(entry, event, 0, err)
+		return
+	}
+	R.stats.addDownloaded()
+	R.ApplyTimes(file, absPath)
+	size, _ = utils.GetFileSize(absPath)
+	R.setJobState(entry, JOB_FILE_DONE, size)
+	R.events.FileCompleted(event)
+}
+
+// isExportCurrent reports whether the export at absPath is at least as new as
+// file on Drive. Exports have no checksum to compare, but their mtime is
+// either set to the Drive modifiedTime or the time of the export, so an edit
+// on Drive makes modifiedTime the later one.
+func isExportCurrent(file *drive.File, absPath string) (int64, bool) {
+	info, err := os.Stat(absPath)
+	if err != nil || info.IsDir() || info.Size() == 0 {
+		return 0, false
+	}
+	modified, err := time.Parse(time.RFC3339, file.ModifiedTime)
 	if err != nil {
-		log.Printf("err while requesting download: retrying download: %s: %v\n", file.Name, err)
-		if strings.Contains(strings.ToLower(err.Error()), "rate") || response != nil && response.StatusCode >= 500 && retry <= 5 {
-			time.Sleep(5 * time.Second)
-			return G.DownloadFile(file, localPath, startByteIndex, retry+1)
+		return info.Size(), true
+	}
+	return info.Size(), !info.ModTime().Before(modified.Truncate(time.Second))
+}
+
+// retryExport runs ExportFile as often as the retry policy allows.
+func (R *jobRun) retryExport(file *drive.File, absPath string, mimeType string, event FileEvent) error {
+	err := R.ExportFile(file, absPath, mimeType)
+	for attempt := 1; err != nil; attempt++ {
+		delay, retry := R.retryDelay(attempt, err)
+		if !retry {
+			err = describeError(err)
+			break
 		}
-		log.Printf("[API-files:get]: (%s) %v\n", file.Id, err)
-		return false
+		R.events.FileRetried(event, attempt, err)
+		if !R.sleep(delay) {
+			break
+		}
+		err = R.ExportFile(file, absPath, mimeType)
+	}
+	if err != nil && R.ctx.Err() != nil {
+		return R.ctx.Err()
+	}
+	return err
+}
+
+func (R *jobRun) setJobState(entry *JobFile, state string, offset int64) {
+	err := R.job.SetState(entry, state, offset)
+	if err != nil {
+		log.Printf("[JournalError]: %v\n", err)
 	}
-	bar := G.GetProgressBar(file.Name, file.Size-startByteIndex)
-	proxyReader := bar.ProxyReader(response.Body)
-	defer proxyReader.Close()
-	_, err = io.Copy(writer, proxyReader)
+}
+
+// failFile records why a file failed, offset is how much of it is kept for
+// a later resume.
+func (R *jobRun) failFile(entry *JobFile, event FileEvent, offset int64, reason error) {
+	R.stats.addFailed()
+	err := R.job.SetFailed(entry, offset, reason)
 	if err != nil {
-		pos, posErr := writer.Seek(0, io.SeekCurrent)
+		log.Printf("[JournalError]: %v\n", err)
+	}
+	R.events.FileFailed(event, reason)
+}
+
+// DownloadFile downloads file from startByteIndex on into the .part file of
+// absPath, feeding hasher along the way. Failed transfers continue where
+// they stopped, as long as the retry policy allows.
+func (R *jobRun) DownloadFile(file *drive.File, absPath string, startByteIndex int64, hasher *FileHasher) error {
+	localPath := partPath(absPath)
+	writer, err := os.OpenFile(localPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	event := newFileEvent(file, absPath)
+	offset := startByteIndex
+	for attempt := 1; ; attempt++ {
+		err = syncHasher(hasher, nil, localPath, offset)
+		if err != nil {
+			return err
+		}
+		err = R.downloadFrom(file, writer, offset, event, hasher)
+		if err == nil {
+			return nil
+		}
+		// Appends always land at the end, so that's where the next attempt
+		// has to continue from.
+		pos, posErr := writer.Seek(0, io.SeekEnd)
 		if posErr != nil {
-			log.Printf("Error while getting current file offset, %v\n", err)
-			return false
-		} else if retry <= MAX_RETRIES {
-			log.Printf("err while copying stream: retrying download: %s: %v\n", file.Name, err)
-			bar.Abort(true)
-			time.Sleep(time.Duration(int64(retry)*2) * time.Second)
-			return G.DownloadFile(file, localPath, pos, retry+1)
-		} else {
-			log.Printf("Error while copying stream, %v\n", err)
+			return posErr
+		}
+		if pos > offset {
+			// Progress was made, only consecutive failures count.
+			attempt = 1
+		}
+		offset = pos
+		delay, retry := R.retryDelay(attempt, err)
+		if !retry {
+			if R.ctx.Err() != nil {
+				return R.ctx.Err()
+			}
+			return describeError(err)
+		}
+		R.events.FileRetried(event, attempt, err)
+		if !R.sleep(delay) {
+			return R.ctx.Err()
 		}
-	} else {
-		G.numFilesDownloaded += 1
 	}
-	return true
+}
+
+// downloadFrom makes a single request for file from offset on and appends
+// the response to writer.
+func (R *jobRun) downloadFrom(file *drive.File, writer *os.File, offset int64, event FileEvent, hasher *FileHasher) error {
+	request := R.srv().Files.Get(file.Id).AcknowledgeAbuse(R.abuse).SupportsAllDrives(true).Context(R.ctx)
+	if offset > 0 {
+		request.Header().Add("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	response, err := request.Download()
+	if err != nil {
+		return err
+	}
+	event.Offset = offset
+	R.events.FileStarted(event)
+	reader := R.progressReader(R.limitReader(response.Body, R.newFileLimiter()), event)
+	defer reader.Close()
+	// Hash while streaming so the file doesn't have to be read again.
+	_, err = io.Copy(io.MultiWriter(R.guardWriter(writer, path.Dir(writer.Name())), hasher), reader)
+	return err
 }
 
 func NewDriveClient() *GoogleDriveClient {
@@ -0,0 +1,89 @@
+package drive
+
+import (
+	"io"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Reasons passed to EventSink.FileSkipped besides the PLAN_* ones used for
+// nodes skipped while walking.
+const (
+	SKIP_DOWNLOADED string = "already downloaded"
+	SKIP_EXPORTED   string = "already exported"
+	SKIP_NO_EXPORT  string = "cannot be exported"
+)
+
+// FileEvent identifies the file an event is about. Path is the final local
+// path, which is unique within a job.
+type FileEvent struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Md5  string `json:"md5Checksum,omitempty"`
+	// Offset is where a transfer starts, only set for FileStarted.
+	Offset int64 `json:"offset,omitempty"`
+}
+
+// EventSink receives what happens while a job runs. The download workers
+// call it concurrently, so implementations must be safe for concurrent use.
+type EventSink interface {
+	FileQueued(f FileEvent)
+	// FileStarted is sent for every transfer attempt, a retry starts again
+	// at f.Offset.
+	FileStarted(f FileEvent)
+	FileProgress(f FileEvent, n int64)
+	FileRetried(f FileEvent, attempt int, err error)
+	FileCompleted(f FileEvent)
+	FileFailed(f FileEvent, err error)
+	FileSkipped(f FileEvent, reason string)
+	JobFinished(result *Result)
+}
+
+// NopSink drops every event, for embedders that only look at the Result.
+type NopSink struct{}
+
+func (NopSink) FileQueued(f FileEvent)                          {}
+func (NopSink) FileStarted(f FileEvent)                         {}
+func (NopSink) FileProgress(f FileEvent, n int64)               {}
+func (NopSink) FileRetried(f FileEvent, attempt int, err error) {}
+func (NopSink) FileCompleted(f FileEvent)                       {}
+func (NopSink) FileFailed(f FileEvent, err error)               {}
+func (NopSink) FileSkipped(f FileEvent, reason string)          {}
+func (NopSink) JobFinished(result *Result)                      {}
+
+func (G *GoogleDriveClient) SetEventSink(sink EventSink) {
+	if sink == nil {
+		sink = NopSink{}
+	}
+	G.events = sink
+}
+
+func newFileEvent(file *drive.File, absPath string) FileEvent {
+	return FileEvent{Id: file.Id, Name: file.Name, Path: absPath, Size: file.Size, Md5: file.Md5Checksum}
+}
+
+// progressReader reports every read of a transfer as FileProgress and
+// counts it in the job stats.
+type progressReader struct {
+	io.ReadCloser
+	events EventSink
+	stats  *jobStats
+	event  FileEvent
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.stats.addBytes(int64(n))
+		r.events.FileProgress(r.event, int64(n))
+	}
+	return n, err
+}
+
+// progressReader wraps body, usually after limitReader, so progress follows
+// the throttled speed.
+func (R *jobRun) progressReader(body io.ReadCloser, event FileEvent) io.ReadCloser {
+	return &progressReader{ReadCloser: body, events: R.events, stats: R.stats, event: event}
+}
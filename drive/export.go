@@ -0,0 +1,157 @@
+package drive
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const GDRIVE_APPS_MIMETYPE_PREFIX string = "application/vnd.google-apps."
+
+// EXPORT_FORMATS maps the extension users pick on the command line to the
+// MIME type Files.Export expects.
+var EXPORT_FORMATS = map[string]string{
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"odt":  "application/vnd.oasis.opendocument.text",
+	"rtf":  "application/rtf",
+	"txt":  "text/plain",
+	"html": "text/html",
+	"epub": "application/epub+zip",
+	"md":   "text/markdown",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"ods":  "application/x-vnd.oasis.opendocument.spreadsheet",
+	"csv":  "text/csv",
+	"tsv":  "text/tab-separated-values",
+	"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"odp":  "application/vnd.oasis.opendocument.presentation",
+	"pdf":  "application/pdf",
+	"png":  "image/png",
+	"jpg":  "image/jpeg",
+	"svg":  "image/svg+xml",
+	"json": "application/vnd.google-apps.script+json",
+}
+
+// DEFAULT_EXPORTS is keyed by the google-apps type, without the common prefix.
+var DEFAULT_EXPORTS = map[string]string{
+	"document":     "docx",
+	"spreadsheet":  "xlsx",
+	"presentation": "pptx",
+	"drawing":      "png",
+	"script":       "json",
+}
+
+func IsWorkspaceFile(file *drive.File) bool {
+	return strings.HasPrefix(file.MimeType, GDRIVE_APPS_MIMETYPE_PREFIX)
+}
+
+// SetExportFormats overrides the default export formats, spec looks like
+// "document=pdf,spreadsheet=csv".
+func (G *GoogleDriveClient) SetExportFormats(spec string) error {
+	G.exports = make(map[string]string)
+	for kind, ext := range DEFAULT_EXPORTS {
+		G.exports[kind] = ext
+	}
+	if spec == "" {
+		return nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid export format: %s", pair)
+		}
+		kind := strings.TrimPrefix(strings.TrimSpace(kv[0]), GDRIVE_APPS_MIMETYPE_PREFIX)
+		ext := strings.ToLower(strings.TrimSpace(kv[1]))
+		if _, ok := EXPORT_FORMATS[ext]; !ok {
+			return fmt.Errorf("unknown export format: %s", ext)
+		}
+		G.exports[kind] = ext
+	}
+	G.printf("Using Export Formats: %s\n", spec)
+	return nil
+}
+
+// exportFormat returns the extension and MIME type a workspace file gets
+// exported as, ok is false for types that can't be exported.
+func (G *GoogleDriveClient) exportFormat(file *drive.File) (string, string, bool) {
+	ext, ok := G.exports[strings.TrimPrefix(file.MimeType, GDRIVE_APPS_MIMETYPE_PREFIX)]
+	if !ok {
+		return "", "", false
+	}
+	return ext, EXPORT_FORMATS[ext], true
+}
+
+func exportPath(absPath string, ext string) string {
+	if strings.HasSuffix(strings.ToLower(absPath), "."+ext) {
+		return absPath
+	}
+	return absPath + "." + ext
+}
+
+func isExportSizeLimitError(err error) bool {
+	if gerr, ok := err.(*googleapi.Error); ok {
+		for _, item := range gerr.Errors {
+			if item.Reason == "exportSizeLimitExceeded" {
+				return true
+			}
+		}
+	}
+	return strings.Contains(err.Error(), "exportSizeLimitExceeded")
+}
+
+// exportFromLink downloads an export through the file's exportLinks, which
+// isn't subject to the size limit of Files.Export.
+func (R *jobRun) exportFromLink(file *drive.File, mimeType string) (*http.Response, error) {
+	meta, err := R.srv().Files.Get(file.Id).Fields("exportLinks").SupportsAllDrives(true).Context(R.ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	link, ok := meta.ExportLinks[mimeType]
+	if !ok {
+		return nil, fmt.Errorf("no export link for %s", mimeType)
+	}
+	request, err := http.NewRequestWithContext(R.ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := R.client().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("export link returned %s", response.Status)
+	}
+	return response, nil
+}
+
+// ExportFile exports file as mimeType into the .part file of absPath.
+func (R *jobRun) ExportFile(file *drive.File, absPath string, mimeType string) error {
+	response, err := R.srv().Files.Export(file.Id, mimeType).Context(R.ctx).Download()
+	if err != nil && isExportSizeLimitError(err) {
+		log.Printf("%s is too large to export, falling back to export link\n", file.Name)
+		response, err = R.exportFromLink(file, mimeType)
+	}
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	writer, err := os.OpenFile(partPath(absPath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	// Exports have no known size up front.
+	event := newFileEvent(file, absPath)
+	event.Size = response.ContentLength
+	R.events.FileStarted(event)
+	reader := R.progressReader(R.limitReader(response.Body, R.newFileLimiter()), event)
+	defer reader.Close()
+	_, err = io.Copy(writer, reader)
+	return err
+}
@@ -0,0 +1,60 @@
+package drive
+
+import (
+	"drivedlgo/utils"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func (G *GoogleDriveClient) SetPreserveTimes(preserve bool, createdTime bool) {
+	G.preserveTimes = preserve
+	G.preserveCreated = createdTime
+}
+
+// ApplyTimes sets the local mtime of absPath to the Drive modifiedTime, and
+// the creation time too where the platform allows it.
+func (G *GoogleDriveClient) ApplyTimes(file *drive.File, absPath string) {
+	if !G.preserveTimes || file.ModifiedTime == "" {
+		return
+	}
+	modified, err := time.Parse(time.RFC3339, file.ModifiedTime)
+	if err != nil {
+		log.Printf("[FileTimeError]: %v\n", err)
+		return
+	}
+	err = os.Chtimes(absPath, modified, modified)
+	if err != nil {
+		log.Printf("[FileTimeError]: %v\n", err)
+		return
+	}
+	if !G.preserveCreated || file.CreatedTime == "" {
+		return
+	}
+	created, err := time.Parse(time.RFC3339, file.CreatedTime)
+	if err == nil {
+		err = utils.SetCreationTime(absPath, created)
+	}
+	if err != nil {
+		log.Printf("[FileTimeError]: %v\n", err)
+	}
+}
+
+// ApplyFolderTimes sets the times of every folder in job, deepest first, since
+// writing into a folder bumps its mtime again.
+func (G *GoogleDriveClient) ApplyFolderTimes(job *Job) {
+	if !G.preserveTimes {
+		return
+	}
+	folders := job.Folders()
+	sort.SliceStable(folders, func(i, j int) bool {
+		return strings.Count(folders[i].Path, "/") > strings.Count(folders[j].Path, "/")
+	})
+	for _, entry := range folders {
+		G.ApplyTimes(entry.DriveFile(), entry.Path)
+	}
+}
@@ -0,0 +1,181 @@
+package drive
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Filter decides which nodes of a folder download are kept, based on their
+// path relative to the downloaded folder. Glob patterns without a slash match
+// the base name at any depth, patterns with a slash match the trailing path
+// components and patterns starting with a slash are anchored at the root. A
+// "**" matches across slashes, "*" and "?" don't.
+type Filter struct {
+	includes []*regexp.Regexp
+	excludes []*regexp.Regexp
+	Metadata *MetadataFilter
+}
+
+// MetadataFilter selects files by the properties Drive returns for them, zero
+// values disable the corresponding check. MimeTypes may end in "/" or "/*" to
+// match a whole family such as video/*.
+type MetadataFilter struct {
+	MinSize        int64
+	MaxSize        int64
+	MimeTypes      []string
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	Owners         []string
+}
+
+func globToRegex(glob string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	anchored := strings.HasPrefix(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+	if anchored {
+		expr.WriteString("^")
+	} else {
+		expr.WriteString("(^|/)")
+	}
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+func compilePatterns(globs []string, regexes []string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, glob := range globs {
+		re, err := globToRegex(glob)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+	for _, expr := range regexes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+func NewFilter(includes []string, excludes []string, includeRegex []string, excludeRegex []string) (*Filter, error) {
+	inc, err := compilePatterns(includes, includeRegex)
+	if err != nil {
+		return nil, err
+	}
+	exc, err := compilePatterns(excludes, excludeRegex)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{includes: inc, excludes: exc}, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, relPath string) bool {
+	for _, re := range patterns {
+		if re.MatchString(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) MatchFile(relPath string) bool {
+	if f == nil {
+		return true
+	}
+	if matchAny(f.excludes, relPath) {
+		return false
+	}
+	return len(f.includes) == 0 || matchAny(f.includes, relPath)
+}
+
+// MatchDir reports whether a folder has to be listed at all. Only excludes
+// prune folders, since any folder may hold files an include matches. A folder
+// is pruned when it matches an exclude itself or when a placeholder child
+// inside it would, as is the case for "*/raw/*".
+func (f *Filter) MatchDir(relPath string) bool {
+	if f == nil {
+		return true
+	}
+	return !matchAny(f.excludes, relPath) && !matchAny(f.excludes, relPath+"/\x00")
+}
+
+func (m *MetadataFilter) matchMimeType(mimeType string) bool {
+	if len(m.MimeTypes) == 0 {
+		return true
+	}
+	for _, pattern := range m.MimeTypes {
+		pattern = strings.TrimSuffix(pattern, "*")
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(mimeType, pattern) || pattern == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MetadataFilter) matchOwner(file *drive.File) bool {
+	if len(m.Owners) == 0 {
+		return true
+	}
+	for _, owner := range file.Owners {
+		for _, wanted := range m.Owners {
+			if strings.EqualFold(owner.EmailAddress, wanted) || strings.EqualFold(owner.DisplayName, wanted) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *MetadataFilter) matchModifiedTime(file *drive.File) bool {
+	if m.ModifiedAfter.IsZero() && m.ModifiedBefore.IsZero() {
+		return true
+	}
+	modified, err := time.Parse(time.RFC3339, file.ModifiedTime)
+	if err != nil {
+		return false
+	}
+	if !m.ModifiedAfter.IsZero() && !modified.After(m.ModifiedAfter) {
+		return false
+	}
+	if !m.ModifiedBefore.IsZero() && !modified.Before(m.ModifiedBefore) {
+		return false
+	}
+	return true
+}
+
+// MatchMetadata reports whether file passes the metadata filters. It is only
+// applied to files, folders are always walked.
+func (f *Filter) MatchMetadata(file *drive.File) bool {
+	if f == nil || f.Metadata == nil {
+		return true
+	}
+	m := f.Metadata
+	if m.MinSize > 0 && file.Size < m.MinSize {
+		return false
+	}
+	if m.MaxSize > 0 && file.Size > m.MaxSize {
+		return false
+	}
+	return m.matchMimeType(file.MimeType) && m.matchModifiedTime(file) && m.matchOwner(file)
+}
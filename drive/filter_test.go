@@ -0,0 +1,72 @@
+package drive
+
+import "testing"
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		glob    string
+		relPath string
+		want    bool
+	}{
+		{"*.mkv", "movie.mkv", true},
+		{"*.mkv", "season 1/movie.mkv", true},
+		{"*.mkv", "movie.mkv.part", false},
+		{"*.mkv", "movie.mp4", false},
+		{"raw/*", "raw/a.dng", true},
+		{"raw/*", "shoot/raw/a.dng", true},
+		{"raw/*", "raw/day 1/a.dng", false},
+		{"raw/**", "raw/day 1/a.dng", true},
+		{"/raw/*", "raw/a.dng", true},
+		{"/raw/*", "shoot/raw/a.dng", false},
+		{"*/raw/*", "shoot/raw/a.dng", true},
+		{"file?.txt", "file1.txt", true},
+		{"file?.txt", "file10.txt", false},
+		{"file?.txt", "file/.txt", false},
+		{"a+b (1).txt", "a+b (1).txt", true},
+		{"a+b (1).txt", "aab (1).txt", false},
+		{"**/notes.md", "notes.md", false},
+		{"**/notes.md", "a/b/notes.md", true},
+	}
+	for _, tt := range tests {
+		re, err := globToRegex(tt.glob)
+		if err != nil {
+			t.Fatalf("globToRegex(%q): %v", tt.glob, err)
+		}
+		if got := re.MatchString(tt.relPath); got != tt.want {
+			t.Errorf("globToRegex(%q) matching %q = %v, want %v", tt.glob, tt.relPath, got, tt.want)
+		}
+	}
+}
+
+func TestMatchDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		includes []string
+		excludes []string
+		relPath  string
+		want     bool
+	}{
+		{"no patterns", nil, nil, "a/b", true},
+		{"includes don't prune", []string{"*.mkv"}, nil, "photos", true},
+		{"excluded folder", nil, []string{"raw"}, "shoot/raw", false},
+		{"other folder", nil, []string{"raw"}, "shoot/edit", true},
+		{"excluded contents", nil, []string{"*/raw/*"}, "shoot/raw", false},
+		{"contents of another folder", nil, []string{"*/raw/*"}, "shoot", true},
+		{"anchored exclude", nil, []string{"/raw"}, "raw", false},
+		{"anchored exclude below root", nil, []string{"/raw"}, "shoot/raw", true},
+		{"file exclude", nil, []string{"*.tmp"}, "cache", true},
+	}
+	for _, tt := range tests {
+		f, err := NewFilter(tt.includes, tt.excludes, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := f.MatchDir(tt.relPath); got != tt.want {
+			t.Errorf("%s: MatchDir(%q) = %v, want %v", tt.name, tt.relPath, got, tt.want)
+		}
+	}
+	var f *Filter
+	if !f.MatchDir("anything") {
+		t.Error("nil Filter: MatchDir = false, want true")
+	}
+}
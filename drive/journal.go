@@ -0,0 +1,259 @@
+package drive
+
+import (
+	"crypto/rand"
+	"drivedlgo/db"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	JOB_FILE_PENDING string = "pending"
+	JOB_FILE_PARTIAL string = "partial"
+	JOB_FILE_DONE    string = "done"
+	JOB_FILE_FAILED  string = "failed"
+	// JOB_FILE_SKIPPED is for files that can't be downloaded at all, like
+	// Workspace types without an export format.
+	JOB_FILE_SKIPPED string = "skipped"
+)
+
+type JobFile struct {
+	Index          int    `json:"index"`
+	Id             string `json:"id"`
+	Name           string `json:"name"`
+	MimeType       string `json:"mimeType"`
+	Md5Checksum    string `json:"md5Checksum"`
+	Sha1Checksum   string `json:"sha1Checksum,omitempty"`
+	Sha256Checksum string `json:"sha256Checksum,omitempty"`
+	Size           int64  `json:"size"`
+	ModifiedTime   string `json:"modifiedTime"`
+	CreatedTime    string `json:"createdTime"`
+	Folder         bool   `json:"folder,omitempty"`
+	Path           string `json:"path"`
+	State          string `json:"state"`
+	Offset         int64  `json:"offset"`
+	Error          string `json:"error,omitempty"`
+}
+
+func (f *JobFile) DriveFile() *drive.File {
+	return &drive.File{
+		Id:             f.Id,
+		Name:           f.Name,
+		MimeType:       f.MimeType,
+		Md5Checksum:    f.Md5Checksum,
+		Sha1Checksum:   f.Sha1Checksum,
+		Sha256Checksum: f.Sha256Checksum,
+		Size:           f.Size,
+		ModifiedTime:   f.ModifiedTime,
+		CreatedTime:    f.CreatedTime,
+	}
+}
+
+// Job is the journal of a single Download invocation. It records every file
+// that traversal resolved together with its transfer state so that an
+// interrupted job can be resumed without walking Drive again.
+type Job struct {
+	Id         string    `json:"id"`
+	NodeId     string    `json:"nodeId"`
+	LocalPath  string    `json:"localPath"`
+	OutputPath string    `json:"outputPath"`
+	Resolved   bool      `json:"resolved"`
+	CreatedAt  time.Time `json:"createdAt"`
+	files      []*JobFile
+	byPath     map[string]*JobFile
+	store      *db.Store
+	mut        sync.Mutex
+}
+
+func newJobId() string {
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func NewJob(store *db.Store, nodeId string, localPath string, outputPath string) (*Job, error) {
+	job := &Job{
+		Id:         newJobId(),
+		NodeId:     nodeId,
+		LocalPath:  localPath,
+		OutputPath: outputPath,
+		CreatedAt:  time.Now(),
+		byPath:     make(map[string]*JobFile),
+		store:      store,
+	}
+	return job, job.save()
+}
+
+func LoadJob(store *db.Store, jobId string) (*Job, error) {
+	data, err := store.Get(db.JobKey(jobId))
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{byPath: make(map[string]*JobFile), store: store}
+	err = json.Unmarshal(data, job)
+	if err != nil {
+		return nil, err
+	}
+	values, err := store.ScanValues(db.JobFilesPrefix(jobId))
+	if err != nil {
+		return nil, err
+	}
+	job.files = make([]*JobFile, len(values))
+	for _, value := range values {
+		entry := &JobFile{}
+		err = json.Unmarshal(value, entry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Index < 0 || entry.Index >= len(job.files) {
+			continue
+		}
+		job.files[entry.Index] = entry
+		job.byPath[entry.Path] = entry
+	}
+	return job, nil
+}
+
+func (j *Job) save() error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return j.store.Put(db.JobKey(j.Id), data)
+}
+
+func (j *Job) saveFile(entry *JobFile) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return j.store.Put(db.JobFileKey(j.Id, entry.Index), data)
+}
+
+// Track returns the journal entry for absPath, adding a pending one if
+// traversal hasn't seen this path before.
+func (j *Job) Track(file *drive.File, absPath string) (*JobFile, error) {
+	return j.track(file, absPath, false)
+}
+
+// TrackFolder records a folder so its times can be applied once the job is
+// done. Folders have nothing to transfer, so they start out done.
+func (j *Job) TrackFolder(file *drive.File, absPath string) (*JobFile, error) {
+	return j.track(file, absPath, true)
+}
+
+func (j *Job) track(file *drive.File, absPath string, folder bool) (*JobFile, error) {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	if entry, ok := j.byPath[absPath]; ok {
+		return entry, nil
+	}
+	state := JOB_FILE_PENDING
+	if folder {
+		state = JOB_FILE_DONE
+	}
+	entry := &JobFile{
+		Index:          len(j.files),
+		Id:             file.Id,
+		Name:           file.Name,
+		MimeType:       file.MimeType,
+		Md5Checksum:    file.Md5Checksum,
+		Sha1Checksum:   file.Sha1Checksum,
+		Sha256Checksum: file.Sha256Checksum,
+		Size:           file.Size,
+		ModifiedTime:   file.ModifiedTime,
+		CreatedTime:    file.CreatedTime,
+		Folder:         folder,
+		Path:           absPath,
+		State:          state,
+	}
+	j.files = append(j.files, entry)
+	j.byPath[absPath] = entry
+	return entry, j.saveFile(entry)
+}
+
+func (j *Job) SetState(entry *JobFile, state string, offset int64) error {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	entry.State = state
+	entry.Offset = offset
+	entry.Error = ""
+	return j.saveFile(entry)
+}
+
+// SetFailed marks entry failed and keeps reason for the job summary.
+func (j *Job) SetFailed(entry *JobFile, offset int64, reason error) error {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	entry.State = JOB_FILE_FAILED
+	entry.Offset = offset
+	entry.Error = reason.Error()
+	return j.saveFile(entry)
+}
+
+// SetSkipped marks entry as one that won't be downloaded, for reason.
+func (j *Job) SetSkipped(entry *JobFile, reason string) error {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	entry.State = JOB_FILE_SKIPPED
+	entry.Offset = 0
+	entry.Error = reason
+	return j.saveFile(entry)
+}
+
+func (j *Job) SetResolved() error {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	j.Resolved = true
+	return j.save()
+}
+
+// Unfinished returns every journal entry that isn't done or skipped yet.
+func (j *Job) Unfinished() []*JobFile {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	var files []*JobFile
+	for _, entry := range j.files {
+		if entry != nil && entry.State != JOB_FILE_DONE && entry.State != JOB_FILE_SKIPPED {
+			files = append(files, entry)
+		}
+	}
+	return files
+}
+
+// Files returns a copy of every journal entry, folders included.
+func (j *Job) Files() []JobFile {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	var files []JobFile
+	for _, entry := range j.files {
+		if entry != nil {
+			files = append(files, *entry)
+		}
+	}
+	return files
+}
+
+func (j *Job) Folders() []*JobFile {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	var folders []*JobFile
+	for _, entry := range j.files {
+		if entry != nil && entry.Folder {
+			folders = append(folders, entry)
+		}
+	}
+	return folders
+}
+
+func (j *Job) Remove() error {
+	err := j.store.DeletePrefix(db.JobFilesPrefix(j.Id))
+	if err != nil {
+		return err
+	}
+	return j.store.Delete(db.JobKey(j.Id))
+}
@@ -0,0 +1,143 @@
+package drive
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event names written by JSONSink.
+const (
+	EVENT_QUEUED    string = "queued"
+	EVENT_STARTED   string = "started"
+	EVENT_PROGRESS  string = "progress"
+	EVENT_RETRIED   string = "retried"
+	EVENT_COMPLETED string = "completed"
+	EVENT_FAILED    string = "failed"
+	EVENT_SKIPPED   string = "skipped"
+	EVENT_FINISHED  string = "finished"
+)
+
+// JSONEvent is one line of JSONSink output. Which fields are set depends
+// on Event.
+type JSONEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	*FileEvent
+	// Bytes is how much of the file is on disk, resumed bytes included.
+	Bytes    int64   `json:"bytes,omitempty"`
+	Speed    float64 `json:"speed,omitempty"`
+	Attempt  int     `json:"attempt,omitempty"`
+	Reason   string  `json:"reason,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	Result   *Result `json:"result,omitempty"`
+}
+
+type jsonTransfer struct {
+	started  time.Time
+	attempt  time.Time
+	bytes    int64
+	lastTick time.Time
+}
+
+// JSONSink writes every event as a line of JSON, for programs wrapping
+// drivedlgo. Progress is written at most once per interval for each file.
+type JSONSink struct {
+	enc       *json.Encoder
+	interval  time.Duration
+	transfers map[string]*jsonTransfer
+	mut       sync.Mutex
+}
+
+func NewJSONSink(w io.Writer, interval time.Duration) *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(w), interval: interval, transfers: make(map[string]*jsonTransfer)}
+}
+
+func (s *JSONSink) write(event *JSONEvent) {
+	event.Time = time.Now()
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.enc.Encode(event)
+}
+
+// take removes and returns the transfer of f, nil if it never started.
+func (s *JSONSink) take(f FileEvent) *jsonTransfer {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	t := s.transfers[f.Path]
+	delete(s.transfers, f.Path)
+	return t
+}
+
+func (s *JSONSink) FileQueued(f FileEvent) {
+	s.write(&JSONEvent{Event: EVENT_QUEUED, FileEvent: &f})
+}
+
+func (s *JSONSink) FileStarted(f FileEvent) {
+	now := time.Now()
+	s.mut.Lock()
+	t, ok := s.transfers[f.Path]
+	if !ok {
+		t = &jsonTransfer{started: now}
+		s.transfers[f.Path] = t
+	}
+	t.attempt = now
+	t.bytes = f.Offset
+	t.lastTick = now
+	s.mut.Unlock()
+	s.write(&JSONEvent{Event: EVENT_STARTED, FileEvent: &f})
+}
+
+func (s *JSONSink) FileProgress(f FileEvent, n int64) {
+	now := time.Now()
+	s.mut.Lock()
+	t := s.transfers[f.Path]
+	if t == nil {
+		s.mut.Unlock()
+		return
+	}
+	t.bytes += n
+	if now.Sub(t.lastTick) < s.interval {
+		s.mut.Unlock()
+		return
+	}
+	t.lastTick = now
+	bytes := t.bytes
+	speed := float64(bytes-f.Offset) / now.Sub(t.attempt).Seconds()
+	s.mut.Unlock()
+	s.write(&JSONEvent{Event: EVENT_PROGRESS, FileEvent: &f, Bytes: bytes, Speed: speed})
+}
+
+func (s *JSONSink) FileRetried(f FileEvent, attempt int, err error) {
+	s.write(&JSONEvent{Event: EVENT_RETRIED, FileEvent: &f, Attempt: attempt, Error: err.Error()})
+}
+
+func (s *JSONSink) FileCompleted(f FileEvent) {
+	event := &JSONEvent{Event: EVENT_COMPLETED, FileEvent: &f, Bytes: f.Size}
+	if t := s.take(f); t != nil {
+		event.Duration = time.Since(t.started).Seconds()
+		if f.Size <= 0 {
+			event.Bytes = t.bytes
+		}
+	}
+	s.write(event)
+}
+
+func (s *JSONSink) FileFailed(f FileEvent, err error) {
+	event := &JSONEvent{Event: EVENT_FAILED, FileEvent: &f, Error: err.Error()}
+	if t := s.take(f); t != nil {
+		event.Bytes = t.bytes
+		event.Duration = time.Since(t.started).Seconds()
+	}
+	s.write(event)
+}
+
+func (s *JSONSink) FileSkipped(f FileEvent, reason string) {
+	s.write(&JSONEvent{Event: EVENT_SKIPPED, FileEvent: &f, Reason: reason})
+}
+
+func (s *JSONSink) JobFinished(result *Result) {
+	s.write(&JSONEvent{Event: EVENT_FINISHED, Result: result})
+}
@@ -0,0 +1,141 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"google.golang.org/api/drive/v3"
+)
+
+type ListEntry struct {
+	Path         string `json:"path"`
+	Id           string `json:"id"`
+	Name         string `json:"name"`
+	MimeType     string `json:"mimeType"`
+	Size         int64  `json:"size"`
+	Md5Checksum  string `json:"md5Checksum,omitempty"`
+	ModifiedTime string `json:"modifiedTime,omitempty"`
+	Folder       bool   `json:"folder,omitempty"`
+}
+
+type ListOptions struct {
+	Recursive bool
+	SortBy    string
+	Reverse   bool
+}
+
+func sortFiles(files []*drive.File, sortBy string, reverse bool) error {
+	var less func(a, b *drive.File) bool
+	switch sortBy {
+	case "", "name":
+		less = func(a, b *drive.File) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case "size":
+		less = func(a, b *drive.File) bool { return a.Size < b.Size }
+	case "modified":
+		less = func(a, b *drive.File) bool { return a.ModifiedTime < b.ModifiedTime }
+	case "mime":
+		less = func(a, b *drive.File) bool { return a.MimeType < b.MimeType }
+	default:
+		return fmt.Errorf("unknown sort key: %s", sortBy)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if reverse {
+			return less(files[j], files[i])
+		}
+		return less(files[i], files[j])
+	})
+	return nil
+}
+
+// List returns the children of nodeId, and their descendants too when
+// opts.Recursive is set. A file id lists just that file.
+func (G *GoogleDriveClient) List(ctx context.Context, nodeId string, opts ListOptions) ([]*ListEntry, error) {
+	run := G.newRun(ctx)
+	root, err := run.getFile(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if root.MimeType != G.GDRIVE_DIR_MIMETYPE {
+		return []*ListEntry{newListEntry(root, root.Name, false)}, nil
+	}
+	var entries []*ListEntry
+	err = run.listNodes(root.Id, "", opts, &entries)
+	if err == nil && run.listErrors > 0 {
+		err = ErrListIncomplete
+	}
+	return entries, err
+}
+
+func newListEntry(file *drive.File, relPath string, folder bool) *ListEntry {
+	return &ListEntry{
+		Path:         relPath,
+		Id:           file.Id,
+		Name:         file.Name,
+		MimeType:     file.MimeType,
+		Size:         file.Size,
+		Md5Checksum:  file.Md5Checksum,
+		ModifiedTime: file.ModifiedTime,
+		Folder:       folder,
+	}
+}
+
+func (R *jobRun) listNodes(nodeId string, relPath string, opts ListOptions, entries *[]*ListEntry) error {
+	files := R.GetFilesByParentId(nodeId)
+	err := sortFiles(files, opts.SortBy, opts.Reverse)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		filePath := path.Join(relPath, file.Name)
+		folder := file.MimeType == R.GDRIVE_DIR_MIMETYPE
+		*entries = append(*entries, newListEntry(file, filePath, folder))
+		if folder && opts.Recursive {
+			err = R.listNodes(file.Id, filePath, opts, entries)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func WriteListJSON(w io.Writer, entries []*ListEntry) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// WriteList prints one entry per line, long adds size, modified time, MIME
+// type, id and md5 columns in front of the path.
+func WriteList(w io.Writer, entries []*ListEntry, long bool) {
+	var total int64
+	for _, entry := range entries {
+		name := entry.Path
+		if entry.Folder {
+			name = color.HiBlueString("%s/", name)
+		}
+		total += entry.Size
+		if !long {
+			fmt.Fprintln(w, name)
+			continue
+		}
+		size := formatBytes(entry.Size)
+		if entry.Folder {
+			size = "-"
+		}
+		md5 := entry.Md5Checksum
+		if md5 == "" {
+			md5 = "-"
+		}
+		fmt.Fprintf(w, "%10s  %-20s  %-40s  %-33s  %-32s  %s\n", size, entry.ModifiedTime, entry.MimeType, entry.Id, md5, name)
+	}
+	if long {
+		fmt.Fprintf(w, "%d entries, %s\n", len(entries), formatBytes(total))
+	}
+}
@@ -0,0 +1,111 @@
+package drive
+
+import (
+	"drivedlgo/db"
+	"drivedlgo/utils"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	CHECK_SIZE  string = "size"
+	CHECK_MTIME string = "mtime"
+	CHECK_MD5   string = "md5"
+)
+
+// hashCacheEntry remembers the md5 of a local file as long as its size and
+// mtime don't change.
+type hashCacheEntry struct {
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"modTime"`
+	Md5Checksum string `json:"md5Checksum"`
+}
+
+func (G *GoogleDriveClient) SetCheckStrategy(strategy string) error {
+	switch strategy {
+	case "":
+		strategy = CHECK_MD5
+	case CHECK_SIZE, CHECK_MTIME, CHECK_MD5:
+	default:
+		return fmt.Errorf("unknown check strategy: %s", strategy)
+	}
+	G.checkStrategy = strategy
+	return nil
+}
+
+// isComplete reports whether the file at absPath is the same as file on
+// Drive. A size mismatch is conclusive for every strategy, mtime falls back
+// to hashing when the times differ.
+func (R *jobRun) isComplete(file *drive.File, absPath string) (bool, error) {
+	info, err := os.Stat(absPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() || info.Size() != file.Size {
+		return false, nil
+	}
+	if file.Md5Checksum == "" || R.checkStrategy == CHECK_SIZE {
+		return true, nil
+	}
+	if R.checkStrategy == CHECK_MTIME {
+		modified, err := time.Parse(time.RFC3339, file.ModifiedTime)
+		if err == nil && modified.Unix() == info.ModTime().Unix() {
+			return true, nil
+		}
+	}
+	hash, err := R.cachedMd5(absPath, info)
+	if err != nil {
+		return false, err
+	}
+	return hash == file.Md5Checksum, nil
+}
+
+// cachedMd5 hashes absPath unless the hash cache has it for the same size
+// and mtime already.
+func (R *jobRun) cachedMd5(absPath string, info os.FileInfo) (string, error) {
+	key := db.HashCacheKey(absPath)
+	if R.store != nil {
+		data, err := R.store.Get(key)
+		if err == nil {
+			entry := &hashCacheEntry{}
+			if json.Unmarshal(data, entry) == nil && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() {
+				return entry.Md5Checksum, nil
+			}
+		}
+	}
+	hash, err := utils.GetFileMd5(absPath)
+	if err != nil {
+		return "", err
+	}
+	R.cacheMd5(absPath, info, hash)
+	return hash, nil
+}
+
+func (R *jobRun) cacheMd5(absPath string, info os.FileInfo, hash string) {
+	if R.store == nil {
+		return
+	}
+	data, err := json.Marshal(&hashCacheEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Md5Checksum: hash})
+	if err == nil {
+		R.store.Put(db.HashCacheKey(absPath), data)
+	}
+}
+
+// rememberMd5 caches the checksum of a file that was just verified while
+// downloading, so the next run doesn't hash it either.
+func (R *jobRun) rememberMd5(file *drive.File, absPath string) {
+	if file.Md5Checksum == "" {
+		return
+	}
+	info, err := os.Stat(absPath)
+	if err == nil {
+		R.cacheMd5(absPath, info, file.Md5Checksum)
+	}
+}
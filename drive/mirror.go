@@ -0,0 +1,186 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+type MirrorOptions struct {
+	DryRun    bool
+	BackupDir string
+	// PlanOutput receives the plan of a dry run as a tree, it isn't written
+	// anywhere when nil.
+	PlanOutput io.Writer
+}
+
+// markRemote records a local path that belongs to the remote tree, so mirror
+// keeps it.
+func (R *jobRun) markRemote(absPath string) {
+	if R.remotePaths != nil {
+		R.remotePaths[filepath.ToSlash(absPath)] = true
+	}
+}
+
+// protectRemote keeps everything below absPath, used for folders that were
+// excluded and so never listed.
+func (R *jobRun) protectRemote(absPath string) {
+	if R.remotePaths != nil {
+		R.protectedPaths = append(R.protectedPaths, filepath.ToSlash(absPath))
+	}
+}
+
+func (R *jobRun) isRemote(slashPath string) bool {
+	if R.remotePaths[slashPath] {
+		return true
+	}
+	for _, suffix := range []string{PART_META_SUFFIX, PART_SUFFIX} {
+		if strings.HasSuffix(slashPath, suffix) && R.remotePaths[strings.TrimSuffix(slashPath, suffix)] {
+			return true
+		}
+	}
+	for _, prefix := range R.protectedPaths {
+		if slashPath == prefix || strings.HasPrefix(slashPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrListIncomplete is returned when some folders couldn't be listed, Mirror
+// doesn't prune anything then.
+var ErrListIncomplete = errors.New("not all folders could be listed")
+
+// Mirror downloads nodeId like Download does and then removes every local
+// file and folder under the destination that isn't on Drive anymore. Files
+// that failed to download don't stop the pruning, the error is returned
+// afterwards.
+func (G *GoogleDriveClient) Mirror(ctx context.Context, nodeId string, localPath string, outputPath string, opts MirrorOptions) error {
+	return G.newRun(ctx).mirror(nodeId, localPath, outputPath, opts)
+}
+
+func (R *jobRun) mirror(nodeId string, localPath string, outputPath string, opts MirrorOptions) error {
+	R.remotePaths = make(map[string]bool)
+	R.protectedPaths = nil
+	R.listErrors = 0
+	var downloadErr error
+	if opts.DryRun {
+		plan, err := R.dryRunWalk(nodeId, localPath, outputPath)
+		if err != nil {
+			return err
+		}
+		if opts.PlanOutput != nil {
+			plan.WriteTree(opts.PlanOutput)
+		}
+	} else {
+		_, downloadErr = R.download(nodeId, localPath, Options{Output: outputPath})
+		if downloadErr != nil && !errors.Is(downloadErr, ErrIncomplete) {
+			return downloadErr
+		}
+	}
+	if R.listErrors > 0 {
+		return fmt.Errorf("%w, not removing anything", ErrListIncomplete)
+	}
+	info, err := os.Stat(R.rootPath)
+	if err != nil {
+		// Nothing has been downloaded yet, so there is nothing to remove.
+		return downloadErr
+	}
+	if !info.IsDir() {
+		R.println("Mirror only removes files inside a downloaded folder, nothing to do.")
+		return downloadErr
+	}
+	R.protectBackupDir(R.rootPath, opts.BackupDir)
+	R.Prune(R.rootPath, opts)
+	return downloadErr
+}
+
+// Prune removes, or moves into opts.BackupDir, everything under root that
+// the last walk didn't mark as remote.
+func (R *jobRun) Prune(root string, opts MirrorOptions) {
+	var files, folders int
+	err := filepath.WalkDir(root, func(localPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if localPath == root || R.isRemote(filepath.ToSlash(localPath)) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, localPath)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			folders += 1
+		} else {
+			files += 1
+		}
+		switch {
+		case opts.DryRun:
+			R.printf("%s %s\n", color.HiRedString("would remove"), rel)
+		case opts.BackupDir != "":
+			err = moveToBackup(localPath, filepath.Join(opts.BackupDir, rel))
+			if err == nil {
+				R.printf("%s %s\n", color.HiYellowString("backed up"), rel)
+			}
+		default:
+			err = os.RemoveAll(localPath)
+			if err == nil {
+				R.printf("%s %s\n", color.HiRedString("removed"), rel)
+			}
+		}
+		if err != nil {
+			log.Printf("[MirrorError]: %v\n", err)
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[MirrorError]: %v\n", err)
+	}
+	verb := "Removed"
+	if opts.DryRun {
+		verb = "Would remove"
+	} else if opts.BackupDir != "" {
+		verb = "Backed up"
+	}
+	R.printf("%s", color.GreenString(fmt.Sprintf("%s %d files and %d folders not on Drive.\n", verb, files, folders)))
+}
+
+// protectBackupDir keeps Prune from walking into a backup dir that lives
+// inside the mirrored folder.
+func (R *jobRun) protectBackupDir(root string, backupDir string) {
+	if backupDir == "" {
+		return
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return
+	}
+	absBackup, err := filepath.Abs(backupDir)
+	if err != nil {
+		return
+	}
+	rel, err := filepath.Rel(absRoot, absBackup)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		R.protectRemote(filepath.Join(root, rel))
+	}
+}
+
+func moveToBackup(localPath string, backupPath string) error {
+	err := os.MkdirAll(filepath.Dir(backupPath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(localPath, backupPath)
+}
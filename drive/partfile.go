@@ -0,0 +1,156 @@
+package drive
+
+import (
+	"drivedlgo/utils"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const PART_SUFFIX string = ".part"
+const PART_META_SUFFIX string = ".part.meta"
+
+// PartMeta is the sidecar written next to a .part file. It identifies the
+// remote revision the partial data belongs to and, for segmented downloads,
+// how far every segment got.
+type PartMeta struct {
+	Id           string            `json:"id"`
+	Md5Checksum  string            `json:"md5Checksum"`
+	Size         int64             `json:"size"`
+	ModifiedTime string            `json:"modifiedTime"`
+	HashOffset   int64             `json:"hashOffset,omitempty"`
+	HashState    map[string][]byte `json:"hashState,omitempty"`
+	Segments     []*PartSegment    `json:"segments,omitempty"`
+}
+
+func partPath(absPath string) string {
+	return absPath + PART_SUFFIX
+}
+
+func partMetaPath(absPath string) string {
+	return absPath + PART_META_SUFFIX
+}
+
+func newPartMeta(file *drive.File) *PartMeta {
+	return &PartMeta{Id: file.Id, Md5Checksum: file.Md5Checksum, Size: file.Size, ModifiedTime: file.ModifiedTime}
+}
+
+func (m *PartMeta) Matches(file *drive.File) bool {
+	return m.Id == file.Id && m.Md5Checksum == file.Md5Checksum && m.Size == file.Size && m.ModifiedTime == file.ModifiedTime
+}
+
+func readPartMeta(absPath string) (*PartMeta, error) {
+	data, err := os.ReadFile(partMetaPath(absPath))
+	if err != nil {
+		return nil, err
+	}
+	meta := &PartMeta{}
+	err = json.Unmarshal(data, meta)
+	return meta, err
+}
+
+func writePartMeta(absPath string, file *drive.File, hasher *FileHasher, segments []*PartSegment) error {
+	meta := newPartMeta(file)
+	meta.Segments = segments
+	if hasher != nil && hasher.Offset > 0 {
+		meta.HashOffset = hasher.Offset
+		meta.HashState = hasher.State()
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(partMetaPath(absPath), data, 0644)
+}
+
+func removePart(absPath string) {
+	os.Remove(partPath(absPath))
+	os.Remove(partMetaPath(absPath))
+}
+
+// partOffset returns how much of file is already in its .part file. A
+// partial whose sidecar doesn't match the remote file counts as nothing.
+func partOffset(file *drive.File, absPath string) int64 {
+	meta, err := readPartMeta(absPath)
+	if err != nil || !meta.Matches(file) {
+		return 0
+	}
+	size, err := utils.GetFileSize(partPath(absPath))
+	if err != nil || size > file.Size {
+		return 0
+	}
+	if len(meta.Segments) > 0 {
+		return writtenBytes(meta.Segments)
+	}
+	return size
+}
+
+// partSegments returns the segments saved for the .part file of absPath, or
+// nil when it wasn't written by a segmented download.
+func partSegments(file *drive.File, absPath string) []*PartSegment {
+	meta, err := readPartMeta(absPath)
+	if err != nil || !meta.Matches(file) {
+		return nil
+	}
+	return meta.Segments
+}
+
+// preparePart gets the .part file ready for a transfer starting at offset
+// and brings hasher up to the bytes it can hash from there. Stale partials
+// are thrown away. The segments of a segmented partial are kept when
+// segmented is set, otherwise the partial is cut back to its contiguous
+// prefix so a single stream can continue it. A file under the final name is
+// never touched here, it's a finished download, possibly of an older
+// revision, and only gets replaced once the new one is verified.
+func preparePart(file *drive.File, absPath string, offset int64, hasher *FileHasher, segmented bool) (int64, error) {
+	if offset == 0 {
+		removePart(absPath)
+	}
+	meta, _ := readPartMeta(absPath)
+	hashed := offset
+	var segments []*PartSegment
+	if meta != nil && len(meta.Segments) > 0 {
+		hashed = contiguousBytes(meta.Segments)
+		if segmented {
+			segments = meta.Segments
+		} else {
+			offset = hashed
+			err := os.Truncate(partPath(absPath), offset)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	err := syncHasher(hasher, meta, partPath(absPath), hashed)
+	if err != nil {
+		return 0, err
+	}
+	return offset, writePartMeta(absPath, file, hasher, segments)
+}
+
+// finishPart verifies the finished .part file against Drive's checksums and
+// moves it to its final name. A mismatching partial is thrown away. Anything
+// hasher hasn't seen yet is read back from disk here, which for a segmented
+// download is everything after its contiguous prefix from the last resume:
+// md5, sha1 and sha256 can only be fed in order, so digests of separate
+// segments can't be combined into the file's checksum.
+func finishPart(file *drive.File, absPath string, hasher *FileHasher) error {
+	part := partPath(absPath)
+	err := syncHasher(hasher, nil, part, file.Size)
+	if err != nil {
+		return err
+	}
+	err = hasher.Verify()
+	if err != nil {
+		removePart(absPath)
+		return fmt.Errorf("%s: %v", file.Name, err)
+	}
+	err = os.Rename(part, absPath)
+	if err != nil {
+		return err
+	}
+	os.Remove(partMetaPath(absPath))
+	return nil
+}
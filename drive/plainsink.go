@@ -0,0 +1,161 @@
+package drive
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+type plainTransfer struct {
+	event   FileEvent
+	bytes   int64
+	started time.Time
+}
+
+// PlainSink prints single lines instead of progress bars, for output that
+// ends up in log files. Every interval it prints the status of each running
+// transfer and of the job as a whole.
+type PlainSink struct {
+	w         io.Writer
+	interval  time.Duration
+	transfers map[string]*plainTransfer
+	queued    int
+	done      int
+	skipped   int
+	failed    int
+	bytes     int64
+	started   time.Time
+	stop      chan struct{}
+	mut       sync.Mutex
+}
+
+func NewPlainSink(w io.Writer, interval time.Duration) *PlainSink {
+	return &PlainSink{w: w, interval: interval, transfers: make(map[string]*plainTransfer)}
+}
+
+func (s *PlainSink) printf(format string, a ...interface{}) {
+	fmt.Fprintf(s.w, "%s "+format, append([]interface{}{time.Now().Format("2006-01-02 15:04:05")}, a...)...)
+}
+
+// start begins the periodic status lines, s.mut must be held.
+func (s *PlainSink) start() {
+	if s.stop != nil {
+		return
+	}
+	s.started = time.Now()
+	s.stop = make(chan struct{})
+	go s.run(s.stop)
+}
+
+func (s *PlainSink) run(stop chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.status()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *PlainSink) status() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	transfers := make([]*plainTransfer, 0, len(s.transfers))
+	for _, t := range s.transfers {
+		transfers = append(transfers, t)
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		return transfers[i].event.Path < transfers[j].event.Path
+	})
+	for _, t := range transfers {
+		speed := float64(t.bytes-t.event.Offset) / time.Since(t.started).Seconds()
+		if t.event.Size > 0 {
+			s.printf("%s: %s / %s (%d%%), %s/s\n", t.event.Name, formatBytes(t.bytes), formatBytes(t.event.Size), t.bytes*100/t.event.Size, formatBytes(int64(speed)))
+		} else {
+			s.printf("%s: %s, %s/s\n", t.event.Name, formatBytes(t.bytes), formatBytes(int64(speed)))
+		}
+	}
+	speed := float64(s.bytes) / time.Since(s.started).Seconds()
+	s.printf("Job: %d of %d files done, %d skipped, %d failed, %d running, %s at %s/s\n",
+		s.done, s.queued, s.skipped, s.failed, len(s.transfers), formatBytes(s.bytes), formatBytes(int64(speed)))
+}
+
+func (s *PlainSink) FileQueued(f FileEvent) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.queued += 1
+	s.start()
+}
+
+func (s *PlainSink) FileStarted(f FileEvent) {
+	s.mut.Lock()
+	s.start()
+	s.transfers[f.Path] = &plainTransfer{event: f, bytes: f.Offset, started: time.Now()}
+	s.mut.Unlock()
+	if f.Offset != 0 {
+		s.printf("Resuming %s at offset %d\n", f.Name, f.Offset)
+	} else {
+		s.printf("Downloading %s (%s)\n", f.Name, formatBytes(f.Size))
+	}
+}
+
+func (s *PlainSink) FileProgress(f FileEvent, n int64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.bytes += n
+	if t := s.transfers[f.Path]; t != nil {
+		t.bytes += n
+	}
+}
+
+func (s *PlainSink) FileRetried(f FileEvent, attempt int, err error) {
+	s.printf("Retrying %s (%d): %v\n", f.Name, attempt, err)
+}
+
+func (s *PlainSink) FileCompleted(f FileEvent) {
+	s.mut.Lock()
+	s.done += 1
+	delete(s.transfers, f.Path)
+	s.mut.Unlock()
+	s.printf("Finished %s\n", f.Name)
+}
+
+func (s *PlainSink) FileFailed(f FileEvent, err error) {
+	s.mut.Lock()
+	s.failed += 1
+	delete(s.transfers, f.Path)
+	s.mut.Unlock()
+	s.printf("Failed %s: %v\n", f.Name, err)
+}
+
+func (s *PlainSink) FileSkipped(f FileEvent, reason string) {
+	switch reason {
+	case SKIP_DOWNLOADED, SKIP_EXPORTED, SKIP_NO_EXPORT:
+		// Only these were queued, the others are skipped while walking.
+		s.mut.Lock()
+		s.skipped += 1
+		s.mut.Unlock()
+		s.printf("%s %s.\n", f.Name, reason)
+	case PLAN_EXCLUDED:
+		s.printf("Skipping excluded folder %s.\n", f.Path)
+	case PLAN_SKIPPED:
+		s.printf("Skipping %s.\n", f.Name)
+	}
+}
+
+func (s *PlainSink) JobFinished(result *Result) {
+	s.mut.Lock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.transfers = make(map[string]*plainTransfer)
+	s.queued, s.done, s.skipped, s.failed, s.bytes = 0, 0, 0, 0, 0
+	s.mut.Unlock()
+	WriteSummary(s.w, result)
+}
@@ -0,0 +1,206 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	PLAN_FOLDER   string = "folder"
+	PLAN_NEW      string = "new"
+	PLAN_RESUME   string = "resume"
+	PLAN_COMPLETE string = "complete"
+	PLAN_EXPORT   string = "export"
+	PLAN_EXCLUDED string = "excluded"
+	PLAN_FILTERED string = "filtered"
+	PLAN_SKIPPED  string = "skipped"
+	PLAN_SHORTCUT string = "shortcut"
+	PLAN_ERROR    string = "error"
+)
+
+type PlanEntry struct {
+	Path   string `json:"path"`
+	Id     string `json:"id"`
+	Folder bool   `json:"folder,omitempty"`
+	Size   int64  `json:"size"`
+	Action string `json:"action"`
+	Offset int64  `json:"offset,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type PlanTotals struct {
+	Files         int     `json:"files"`
+	FilesToFetch  int     `json:"filesToFetch"`
+	Complete      int     `json:"complete"`
+	Skipped       int     `json:"skipped"`
+	BytesTotal    int64   `json:"bytesTotal"`
+	BytesToFetch  int64   `json:"bytesToFetch"`
+	Rate          int64   `json:"rate,omitempty"`
+	EstimatedSecs float64 `json:"estimatedSeconds,omitempty"`
+}
+
+// Plan is what a dry run found: one entry per node in traversal order and
+// the totals over all of them.
+type Plan struct {
+	Entries []*PlanEntry `json:"entries"`
+	Totals  PlanTotals   `json:"totals"`
+	mut     sync.Mutex
+}
+
+func (p *Plan) Add(entry *PlanEntry) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.Entries = append(p.Entries, entry)
+	if entry.Folder {
+		return
+	}
+	p.Totals.Files += 1
+	switch entry.Action {
+	case PLAN_NEW, PLAN_RESUME, PLAN_EXPORT:
+		p.Totals.FilesToFetch += 1
+		p.Totals.BytesTotal += entry.Size
+		p.Totals.BytesToFetch += entry.Size - entry.Offset
+	case PLAN_COMPLETE:
+		p.Totals.Complete += 1
+		p.Totals.BytesTotal += entry.Size
+	default:
+		p.Totals.Skipped += 1
+	}
+}
+
+// Estimate fills in the time the transfer would take at rate bytes/s.
+func (p *Plan) Estimate(rate int64) {
+	if rate <= 0 {
+		return
+	}
+	p.Totals.Rate = rate
+	p.Totals.EstimatedSecs = float64(p.Totals.BytesToFetch) / float64(rate)
+}
+
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+func planColor(action string) func(format string, a ...interface{}) string {
+	switch action {
+	case PLAN_NEW, PLAN_EXPORT:
+		return color.HiGreenString
+	case PLAN_RESUME:
+		return color.HiYellowString
+	case PLAN_COMPLETE:
+		return color.HiBlueString
+	case PLAN_ERROR:
+		return color.HiRedString
+	}
+	return color.WhiteString
+}
+
+func (p *Plan) WriteTree(w io.Writer) {
+	for _, entry := range p.Entries {
+		indent := strings.Repeat("  ", strings.Count(entry.Path, "/"))
+		name := path.Base(entry.Path)
+		if entry.Folder {
+			name += "/"
+		}
+		action := entry.Action
+		if entry.Action == PLAN_RESUME {
+			action = fmt.Sprintf("resume at offset %d", entry.Offset)
+		}
+		if entry.Error != "" {
+			action = fmt.Sprintf("%s: %s", action, entry.Error)
+		}
+		if entry.Folder && entry.Action == PLAN_FOLDER {
+			fmt.Fprintf(w, "%s%s\n", indent, name)
+			continue
+		}
+		fmt.Fprintf(w, "%s%s [%s] %s\n", indent, name, planColor(entry.Action)("%s", action), formatBytes(entry.Size))
+	}
+	t := p.Totals
+	fmt.Fprintf(w, "\n%d files, %d to download (%s of %s), %d already complete, %d skipped.\n",
+		t.Files, t.FilesToFetch, formatBytes(t.BytesToFetch), formatBytes(t.BytesTotal), t.Complete, t.Skipped)
+	if t.Rate > 0 {
+		eta := time.Duration(t.EstimatedSecs * float64(time.Second)).Round(time.Second)
+		fmt.Fprintf(w, "Estimated time at %s/s: %s\n", formatBytes(t.Rate), eta)
+	}
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// PlanFile applies the same checks HandleDownloadFile does and reports what
+// would happen to file without touching it.
+func (R *jobRun) PlanFile(file *drive.File, absPath string) *PlanEntry {
+	entry := &PlanEntry{Path: R.relativePath(absPath), Id: file.Id, Size: file.Size}
+	if IsWorkspaceFile(file) {
+		ext, _, ok := R.exportFormat(file)
+		if !ok {
+			entry.Action = PLAN_SKIPPED
+			return entry
+		}
+		entry.Path = exportPath(entry.Path, ext)
+		if _, current := isExportCurrent(file, exportPath(absPath, ext)); current {
+			entry.Action = PLAN_COMPLETE
+		} else {
+			entry.Action = PLAN_EXPORT
+		}
+		return entry
+	}
+	exists, offset, err := R.CheckLocalFile(file, absPath, nil)
+	switch {
+	case err != nil:
+		entry.Action = PLAN_ERROR
+		entry.Error = err.Error()
+	case exists:
+		entry.Action = PLAN_COMPLETE
+	case offset > 0 && offset < file.Size:
+		entry.Action = PLAN_RESUME
+		entry.Offset = offset
+	default:
+		entry.Action = PLAN_NEW
+	}
+	return entry
+}
+
+// DryRun walks nodeId like Download does but only builds the plan.
+func (G *GoogleDriveClient) DryRun(ctx context.Context, nodeId string, localPath string, outputPath string) (*Plan, error) {
+	return G.newRun(ctx).dryRunWalk(nodeId, localPath, outputPath)
+}
+
+func (R *jobRun) dryRunWalk(nodeId string, localPath string, outputPath string) (*Plan, error) {
+	closeStore, err := R.openStore()
+	if err == nil {
+		// Only needed for the hash cache, a dry run works without it.
+		defer closeStore()
+	}
+	R.dryRun = true
+	R.plan = &Plan{}
+	defer func() {
+		R.dryRun = false
+	}()
+	err = R.Walk(nodeId, localPath, outputPath)
+	if err != nil {
+		return nil, err
+	}
+	return R.plan, nil
+}
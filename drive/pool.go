@@ -0,0 +1,110 @@
+package drive
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// workerPool runs queued tasks on a fixed number of goroutines. Each job
+// gets its own pool, so separate clients never share workers.
+type workerPool struct {
+	tasks chan func()
+	wg    sync.WaitGroup
+}
+
+func newWorkerPool(workers int) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &workerPool{tasks: make(chan func())}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for task := range p.tasks {
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit blocks until a worker is free to take task.
+func (p *workerPool) Submit(task func()) {
+	p.tasks <- task
+}
+
+// Close waits for every submitted task to finish, the pool can't be used
+// afterwards.
+func (p *workerPool) Close() {
+	close(p.tasks)
+	p.wg.Wait()
+}
+
+// Stats counts what happened to the files of a job.
+type Stats struct {
+	Downloaded int64 `json:"downloaded"`
+	Skipped    int64 `json:"skipped"`
+	Failed     int64 `json:"failed"`
+	Resumed    int64 `json:"resumed"`
+	// Bytes is what was transferred by this run, resumed bytes excluded.
+	Bytes int64 `json:"bytes"`
+}
+
+// jobStats is updated from the download workers, hence the atomics.
+type jobStats struct {
+	downloaded int64
+	skipped    int64
+	failed     int64
+	resumed    int64
+	bytes      int64
+}
+
+func (s *jobStats) addDownloaded() {
+	atomic.AddInt64(&s.downloaded, 1)
+}
+
+func (s *jobStats) addSkipped() {
+	atomic.AddInt64(&s.skipped, 1)
+}
+
+func (s *jobStats) addFailed() {
+	atomic.AddInt64(&s.failed, 1)
+}
+
+func (s *jobStats) addResumed() {
+	atomic.AddInt64(&s.resumed, 1)
+}
+
+func (s *jobStats) addBytes(n int64) {
+	atomic.AddInt64(&s.bytes, n)
+}
+
+func (s *jobStats) reset() {
+	atomic.StoreInt64(&s.downloaded, 0)
+	atomic.StoreInt64(&s.skipped, 0)
+	atomic.StoreInt64(&s.failed, 0)
+	atomic.StoreInt64(&s.resumed, 0)
+	atomic.StoreInt64(&s.bytes, 0)
+}
+
+func (s *jobStats) Snapshot() Stats {
+	return Stats{
+		Downloaded: atomic.LoadInt64(&s.downloaded),
+		Skipped:    atomic.LoadInt64(&s.skipped),
+		Failed:     atomic.LoadInt64(&s.failed),
+		Resumed:    atomic.LoadInt64(&s.resumed),
+		Bytes:      atomic.LoadInt64(&s.bytes),
+	}
+}
+
+// Stats returns the counters of the job started last, Result.Stats has
+// them for every job.
+func (G *GoogleDriveClient) Stats() Stats {
+	G.statsMut.Lock()
+	defer G.statsMut.Unlock()
+	if G.lastStats == nil {
+		return Stats{}
+	}
+	return G.lastStats.Snapshot()
+}
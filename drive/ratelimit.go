@@ -0,0 +1,102 @@
+package drive
+
+import (
+	"drivedlgo/utils"
+	"io"
+	"sync"
+	"time"
+)
+
+const RATE_LIMIT_CHUNK int = 32 * 1024
+
+// RateLimiter is a token bucket shared by every reader it throttles. Readers
+// may overdraw it, in which case they sleep until the debt is paid back.
+type RateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	mut    sync.Mutex
+}
+
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &RateLimiter{rate: float64(bytesPerSec), tokens: float64(bytesPerSec), last: time.Now()}
+}
+
+func (l *RateLimiter) WaitN(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mut.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mut.Unlock()
+	time.Sleep(wait)
+}
+
+type rateLimitedReader struct {
+	io.ReadCloser
+	limiters []*RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > RATE_LIMIT_CHUNK {
+		p = p[:RATE_LIMIT_CHUNK]
+	}
+	n, err := r.ReadCloser.Read(p)
+	for _, limiter := range r.limiters {
+		limiter.WaitN(n)
+	}
+	return n, err
+}
+
+// limitReader throttles body by the global limiter and the optional per-file
+// one. It is meant to sit below bar.ProxyReader so bars show throttled speed.
+func (G *GoogleDriveClient) limitReader(body io.ReadCloser, fileLimiter *RateLimiter) io.ReadCloser {
+	var limiters []*RateLimiter
+	if G.rateLimiter != nil {
+		limiters = append(limiters, G.rateLimiter)
+	}
+	if fileLimiter != nil {
+		limiters = append(limiters, fileLimiter)
+	}
+	if len(limiters) == 0 {
+		return body
+	}
+	return &rateLimitedReader{ReadCloser: body, limiters: limiters}
+}
+
+func (G *GoogleDriveClient) newFileLimiter() *RateLimiter {
+	return NewRateLimiter(G.fileRateLimit)
+}
+
+func (G *GoogleDriveClient) SetRateLimit(limit string, fileLimit string) error {
+	rate, err := utils.ParseByteSize(limit)
+	if err != nil {
+		return err
+	}
+	fileRate, err := utils.ParseByteSize(fileLimit)
+	if err != nil {
+		return err
+	}
+	if rate > 0 {
+		G.printf("Using Rate-Limit: %s/s\n", limit)
+	}
+	if fileRate > 0 {
+		G.printf("Using Per-File Rate-Limit: %s/s\n", fileLimit)
+	}
+	G.rateLimiter = NewRateLimiter(rate)
+	G.fileRateLimit = fileRate
+	return nil
+}
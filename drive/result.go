@@ -0,0 +1,162 @@
+package drive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// ErrIncomplete is returned when a job ended with files left to
+	// download, the Result says which ones.
+	ErrIncomplete = errors.New("download is incomplete")
+	// ErrNotEnoughSpace is returned by Preflight when the files don't fit
+	// at the destination.
+	ErrNotEnoughSpace = errors.New("not enough free space")
+	// ErrAuth is returned when the client couldn't be authorized.
+	ErrAuth = errors.New("authorization failed")
+)
+
+// IsNotFound reports whether err comes from Drive not knowing an id, or not
+// sharing it with the account.
+func IsNotFound(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusNotFound
+}
+
+// IsAuthError reports whether err means the credentials or token are not
+// valid (anymore).
+func IsAuthError(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusUnauthorized
+	}
+	var rerr *oauth2.RetrieveError
+	return errors.Is(err, ErrAuth) || errors.As(err, &rerr)
+}
+
+// Options are the settings of a single Download call. Everything that
+// applies to the client as a whole is set through its setters.
+type Options struct {
+	// Output is the local name of the downloaded file or folder, the name
+	// on Drive when empty.
+	Output string
+}
+
+// FileResult is the outcome for one file of a job.
+type FileResult struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// DrivePath is Path relative to the destination, which is the path on
+	// Drive starting at the downloaded folder.
+	DrivePath string `json:"drivePath"`
+	Size      int64  `json:"size"`
+	State     string `json:"state"`
+	// Offset is how much of a partial or failed file is on disk.
+	Offset int64  `json:"offset"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Result describes what a Download or Resume call did.
+type Result struct {
+	JobId string `json:"jobId"`
+	// Complete is set when every file of the job was downloaded, the
+	// journal is removed then and the job can't be resumed.
+	Complete bool          `json:"complete"`
+	Stats    Stats         `json:"stats"`
+	Files    []FileResult  `json:"files"`
+	Duration time.Duration `json:"durationNs"`
+}
+
+// Failed returns the files that didn't get downloaded, skipped ones aside.
+func (r *Result) Failed() []FileResult {
+	var files []FileResult
+	for _, f := range r.Files {
+		if f.State != JOB_FILE_DONE && f.State != JOB_FILE_SKIPPED {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Skipped returns the files that can't be downloaded at all.
+func (r *Result) Skipped() []FileResult {
+	var files []FileResult
+	for _, f := range r.Files {
+		if f.State == JOB_FILE_SKIPPED {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func (R *jobRun) newResult(job *Job, startTime time.Time) *Result {
+	result := &Result{
+		JobId:    job.Id,
+		Stats:    R.stats.Snapshot(),
+		Duration: time.Since(startTime),
+	}
+	for _, entry := range job.Files() {
+		if entry.Folder {
+			continue
+		}
+		result.Files = append(result.Files, FileResult{
+			Id:        entry.Id,
+			Name:      entry.Name,
+			Path:      entry.Path,
+			DrivePath: drivePath(job.LocalPath, entry.Path),
+			Size:      entry.Size,
+			State:     entry.State,
+			Offset:    entry.Offset,
+			Error:     entry.Error,
+		})
+	}
+	result.Complete = job.Resolved && len(result.Failed()) == 0
+	return result
+}
+
+func drivePath(localPath string, absPath string) string {
+	localPath = path.Clean(localPath)
+	if localPath == "." {
+		return absPath
+	}
+	return strings.TrimPrefix(absPath, localPath+"/")
+}
+
+// WriteSummary prints the totals of result followed by every file that
+// didn't get downloaded and why.
+func WriteSummary(w io.Writer, result *Result) {
+	st := result.Stats
+	fmt.Fprintf(w, "%s", color.GreenString(fmt.Sprintf("Downloaded %d files (%s) in %s, %d skipped, %d resumed, %d failed.\n",
+		st.Downloaded, formatBytes(st.Bytes), result.Duration.Round(time.Second), st.Skipped, st.Resumed, st.Failed)))
+	failed := result.Failed()
+	if len(failed) > 0 {
+		fmt.Fprintf(w, "%s", color.RedString(fmt.Sprintf("%d files were not downloaded:\n", len(failed))))
+		for _, f := range failed {
+			reason := f.Error
+			if reason == "" {
+				reason = f.State
+			}
+			fmt.Fprintf(w, "  %s (%s): %s\n", f.DrivePath, f.Id, reason)
+		}
+	}
+	skipped := result.Skipped()
+	if len(skipped) > 0 {
+		fmt.Fprintf(w, "%s", color.YellowString(fmt.Sprintf("%d files can't be downloaded:\n", len(skipped))))
+		for _, f := range skipped {
+			fmt.Fprintf(w, "  %s (%s): %s\n", f.DrivePath, f.Id, f.Error)
+		}
+	}
+	if !result.Complete {
+		fmt.Fprintf(w, "%s", color.YellowString(fmt.Sprintf("Job %s is incomplete, continue it with: resume %s\n", result.JobId, result.JobId)))
+	}
+}
@@ -0,0 +1,154 @@
+package drive
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	DEFAULT_RETRY_DELAY     time.Duration = time.Second
+	DEFAULT_RETRY_MAX_DELAY time.Duration = time.Minute
+)
+
+// Reasons of googleapi errors that go away when backing off.
+var retryableReasons = map[string]bool{
+	"userRateLimitExceeded":    true,
+	"rateLimitExceeded":        true,
+	"sharingRateLimitExceeded": true,
+	"backendError":             true,
+	"internalError":            true,
+}
+
+// Reasons of googleapi errors that retrying won't fix, at least not within
+// the same run.
+var fatalReasons = map[string]bool{
+	"downloadQuotaExceeded":       true,
+	"cannotDownloadAbusiveFile":   true,
+	"dailyLimitExceeded":          true,
+	"quotaExceeded":               true,
+	"notFound":                    true,
+	"insufficientFilePermissions": true,
+	"fileNotDownloadable":         true,
+	"appNotAuthorizedToFile":      true,
+}
+
+// RetryPolicy decides whether a failed request is retried and how long to
+// wait before that. Delays grow exponentially from BaseDelay up to MaxDelay
+// with jitter, unless Drive asks for a specific wait through Retry-After.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxRetries: MAX_RETRIES, BaseDelay: DEFAULT_RETRY_DELAY, MaxDelay: DEFAULT_RETRY_MAX_DELAY}
+}
+
+func (G *GoogleDriveClient) SetRetryPolicy(policy RetryPolicy) {
+	if policy.MaxRetries < 0 {
+		policy.MaxRetries = 0
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = DEFAULT_RETRY_DELAY
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	G.printf("Using Retries: %d, backing off %s up to %s\n", policy.MaxRetries, policy.BaseDelay, policy.MaxDelay)
+	G.retry = policy
+}
+
+// isRetryable tells temporary failures apart from ones that would fail the
+// same way again.
+func isRetryable(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		for _, item := range gerr.Errors {
+			if fatalReasons[item.Reason] {
+				return false
+			}
+		}
+		for _, item := range gerr.Errors {
+			if retryableReasons[item.Reason] {
+				return true
+			}
+		}
+		return gerr.Code == http.StatusTooManyRequests || gerr.Code == http.StatusRequestTimeout || gerr.Code >= 500
+	}
+	// Local file errors, everything else is most likely the network.
+	var perr *os.PathError
+	return !errors.As(err, &perr)
+}
+
+// retryAfter returns the wait Drive asked for, 0 if it didn't.
+func retryAfter(err error) time.Duration {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Header == nil {
+		return 0
+	}
+	value := gerr.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// Delay returns how long to wait before retrying after attempt failed with
+// err, and false if it shouldn't be retried.
+func (p RetryPolicy) Delay(attempt int, err error) (time.Duration, bool) {
+	if err == nil || attempt > p.MaxRetries || !isRetryable(err) {
+		return 0, false
+	}
+	if wait := retryAfter(err); wait > 0 {
+		return wait, true
+	}
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	// Full jitter over the upper half, so parallel workers spread out.
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)), true
+}
+
+// retryDelay applies the client's policy, nothing is retried once the
+// operation got cancelled.
+func (R *jobRun) retryDelay(attempt int, err error) (time.Duration, bool) {
+	if R.ctx.Err() != nil {
+		return 0, false
+	}
+	return R.retry.Delay(attempt, err)
+}
+
+// describeError adds a hint to errors the user can do something about.
+func describeError(err error) error {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return err
+	}
+	for _, item := range gerr.Errors {
+		switch item.Reason {
+		case "cannotDownloadAbusiveFile":
+			return fmt.Errorf("%w (use --acknowledge-abuse to download it anyway)", err)
+		case "downloadQuotaExceeded":
+			return fmt.Errorf("%w (the download quota of this file is used up, try again later)", err)
+		}
+	}
+	return err
+}
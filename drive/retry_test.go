@@ -0,0 +1,46 @@
+package drive
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 5, BaseDelay: time.Second, MaxDelay: 8 * time.Second}
+	serverErr := &googleapi.Error{Code: http.StatusInternalServerError}
+	tests := []struct {
+		name    string
+		attempt int
+		err     error
+		retry   bool
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"no error", 1, nil, false, 0, 0},
+		{"first attempt", 1, serverErr, true, 500 * time.Millisecond, time.Second},
+		{"doubled", 3, serverErr, true, 2 * time.Second, 4 * time.Second},
+		{"capped", 5, serverErr, true, 4 * time.Second, 8 * time.Second},
+		{"out of retries", 6, serverErr, false, 0, 0},
+		{"network error", 1, errors.New("connection reset"), true, 500 * time.Millisecond, time.Second},
+		{"local file error", 1, &os.PathError{Op: "open", Path: "x", Err: os.ErrPermission}, false, 0, 0},
+		{"not found", 1, &googleapi.Error{Code: http.StatusNotFound}, false, 0, 0},
+		{"rate limited", 1, &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, true, 500 * time.Millisecond, time.Second},
+		{"quota exceeded", 1, &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "downloadQuotaExceeded"}}}, false, 0, 0},
+		{"retry-after", 1, &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"30"}}}, true, 30 * time.Second, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		delay, retry := policy.Delay(tt.attempt, tt.err)
+		if retry != tt.retry {
+			t.Errorf("%s: retry = %v, want %v", tt.name, retry, tt.retry)
+			continue
+		}
+		if delay < tt.min || delay > tt.max {
+			t.Errorf("%s: delay = %s, want between %s and %s", tt.name, delay, tt.min, tt.max)
+		}
+	}
+}
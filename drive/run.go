@@ -0,0 +1,39 @@
+package drive
+
+import (
+	"context"
+	"drivedlgo/db"
+)
+
+// jobRun is the state of a single Download, Resume, RunJob, DryRun, Mirror,
+// Sync or List call. The account, settings and event sink come from the
+// client it embeds and are shared by every run.
+type jobRun struct {
+	*GoogleDriveClient
+	ctx            context.Context
+	store          *db.Store
+	job            *Job
+	stats          *jobStats
+	walking        map[string]bool
+	localPaths     map[string]string
+	links          []pendingLink
+	rootPath       string
+	dryRun         bool
+	plan           *Plan
+	remotePaths    map[string]bool
+	protectedPaths []string
+	syncIndex      *SyncIndex
+	listErrors     int
+}
+
+// newRun starts a run whose API requests and retries stop once ctx is done.
+func (G *GoogleDriveClient) newRun(ctx context.Context) *jobRun {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	stats := &jobStats{}
+	G.statsMut.Lock()
+	G.lastStats = stats
+	G.statsMut.Unlock()
+	return &jobRun{GoogleDriveClient: G, ctx: ctx, stats: stats, walking: make(map[string]bool), localPaths: make(map[string]string)}
+}
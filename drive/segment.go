@@ -0,0 +1,226 @@
+package drive
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const MIN_SEGMENT_SIZE int64 = 8 * 1024 * 1024
+
+// PartSegment is a byte range of a segmented download, saved in the part
+// sidecar so an interrupted transfer can pick up every range where it left
+// off.
+type PartSegment struct {
+	Start   int64 `json:"start"`
+	End     int64 `json:"end"`
+	Written int64 `json:"written"`
+}
+
+func (s *PartSegment) offset() int64 {
+	return s.Start + s.Written
+}
+
+func (s *PartSegment) done() bool {
+	return s.offset() > s.End
+}
+
+// segmentedPart tracks the segments of a .part file and saves their progress
+// to its sidecar every HASH_CHECKPOINT_INTERVAL bytes.
+type segmentedPart struct {
+	mut             sync.Mutex
+	file            *drive.File
+	absPath         string
+	hasher          *FileHasher
+	segments        []*PartSegment
+	sinceCheckpoint int64
+}
+
+func (p *segmentedPart) advance(seg *PartSegment, n int64) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	seg.Written += n
+	p.sinceCheckpoint += n
+	if p.sinceCheckpoint >= HASH_CHECKPOINT_INTERVAL {
+		p.sinceCheckpoint = 0
+		p.save()
+	}
+}
+
+// save must be called with mut held.
+func (p *segmentedPart) save() {
+	err := writePartMeta(p.absPath, p.file, p.hasher, p.segments)
+	if err != nil {
+		log.Printf("[PartFileError]: %v\n", err)
+	}
+}
+
+// segmentWriter writes sequentially into the range of a single segment, so
+// that several segments can share a single file handle.
+type segmentWriter struct {
+	part   *segmentedPart
+	seg    *PartSegment
+	writer *os.File
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.WriteAt(p, w.seg.offset())
+	w.part.advance(w.seg, int64(n))
+	return n, err
+}
+
+func (G *GoogleDriveClient) SetSegments(count int) {
+	if count < 1 {
+		count = 1
+	}
+	G.printf("Using Segments: %d\n", count)
+	G.segments = count
+}
+
+// canSegment reports whether file can be fetched over several connections,
+// either from scratch or by continuing the segments of its .part file.
+func (G *GoogleDriveClient) canSegment(file *drive.File, absPath string, startByteIndex int64) bool {
+	if G.segments < 2 || file.Size < MIN_SEGMENT_SIZE*2 {
+		return false
+	}
+	return startByteIndex == 0 || len(partSegments(file, absPath)) > 0
+}
+
+func splitSegments(size int64, count int) []*PartSegment {
+	if max := size / MIN_SEGMENT_SIZE; int64(count) > max {
+		count = int(max)
+	}
+	segSize := size / int64(count)
+	segments := make([]*PartSegment, 0, count)
+	var start int64
+	for i := 0; i < count; i++ {
+		end := start + segSize - 1
+		if i == count-1 {
+			end = size - 1
+		}
+		segments = append(segments, &PartSegment{Start: start, End: end})
+		start = end + 1
+	}
+	return segments
+}
+
+// contiguousBytes returns the number of bytes from the beginning of the file
+// that have been fully written, which is where a later single-stream resume
+// can safely continue from.
+func contiguousBytes(segments []*PartSegment) int64 {
+	var total int64
+	for _, seg := range segments {
+		if !seg.done() {
+			return total + seg.Written
+		}
+		total = seg.End + 1
+	}
+	return total
+}
+
+// writtenBytes returns the number of bytes written over all segments.
+func writtenBytes(segments []*PartSegment) int64 {
+	var total int64
+	for _, seg := range segments {
+		total += seg.Written
+	}
+	return total
+}
+
+// DownloadFileSegmented downloads file into the .part file of absPath over
+// several connections at once. Segments saved in the part sidecar by an
+// earlier attempt continue where they stopped. hasher isn't fed while the
+// segments arrive out of order, finishPart reads the file back to verify it.
+func (R *jobRun) DownloadFileSegmented(file *drive.File, absPath string, hasher *FileHasher) error {
+	flags := os.O_WRONLY | os.O_CREATE
+	segments := partSegments(file, absPath)
+	if len(segments) == 0 {
+		flags |= os.O_TRUNC
+		segments = splitSegments(file.Size, R.segments)
+	}
+	writer, err := os.OpenFile(partPath(absPath), flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	part := &segmentedPart{file: file, absPath: absPath, hasher: hasher, segments: segments}
+	part.mut.Lock()
+	part.save()
+	part.mut.Unlock()
+	event := newFileEvent(file, absPath)
+	event.Offset = writtenBytes(segments)
+	R.events.FileStarted(event)
+	fileLimiter := R.newFileLimiter()
+	errs := make([]error, len(segments))
+	var segWg sync.WaitGroup
+	for i, seg := range segments {
+		if seg.done() {
+			continue
+		}
+		segWg.Add(1)
+		go func(i int, seg *PartSegment) {
+			defer segWg.Done()
+			errs[i] = R.downloadSegment(file, writer, part, seg, event, fileLimiter)
+		}(i, seg)
+	}
+	segWg.Wait()
+	// Record how far every segment got, the next run resumes them all.
+	part.mut.Lock()
+	part.save()
+	part.mut.Unlock()
+	for i, seg := range segments {
+		if !seg.done() {
+			return fmt.Errorf("segment %d-%d: %w", seg.Start, seg.End, errs[i])
+		}
+	}
+	return nil
+}
+
+// downloadSegment returns the last error once the retry policy gives up on
+// seg.
+func (R *jobRun) downloadSegment(file *drive.File, writer *os.File, part *segmentedPart, seg *PartSegment, event FileEvent, fileLimiter *RateLimiter) error {
+	var lastErr error
+	for attempt := 0; !seg.done(); attempt++ {
+		if R.ctx.Err() != nil {
+			return R.ctx.Err()
+		}
+		if lastErr != nil {
+			delay, retry := R.retryDelay(attempt, lastErr)
+			if !retry {
+				if R.ctx.Err() != nil {
+					return R.ctx.Err()
+				}
+				return describeError(lastErr)
+			}
+			R.events.FileRetried(event, attempt, lastErr)
+			if !R.sleep(delay) {
+				return R.ctx.Err()
+			}
+		}
+		request := R.srv().Files.Get(file.Id).AcknowledgeAbuse(R.abuse).SupportsAllDrives(true).Context(R.ctx)
+		request.Header().Add("Range", fmt.Sprintf("bytes=%d-%d", seg.offset(), seg.End))
+		response, err := request.Download()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reader := R.progressReader(R.limitReader(response.Body, fileLimiter), event)
+		sw := R.guardWriter(&segmentWriter{part: part, seg: seg, writer: writer}, path.Dir(writer.Name()))
+		n, err := io.Copy(sw, io.LimitReader(reader, seg.End-seg.offset()+1))
+		reader.Close()
+		if n > 0 {
+			// Progress was made, only consecutive failures count.
+			attempt = 0
+		}
+		lastErr = err
+		if err == nil && !seg.done() {
+			lastErr = io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package drive
+
+import "testing"
+
+func TestSplitSegments(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		name  string
+		size  int64
+		count int
+		want  []PartSegment
+	}{
+		{"even split", 32 * mb, 4, []PartSegment{
+			{Start: 0, End: 8*mb - 1},
+			{Start: 8 * mb, End: 16*mb - 1},
+			{Start: 16 * mb, End: 24*mb - 1},
+			{Start: 24 * mb, End: 32*mb - 1},
+		}},
+		{"last one takes the rest", 16*mb + 3, 2, []PartSegment{
+			{Start: 0, End: 8*mb + 1 - 1},
+			{Start: 8*mb + 1, End: 16*mb + 2},
+		}},
+		{"capped by the minimum size", 20 * mb, 8, []PartSegment{
+			{Start: 0, End: 10*mb - 1},
+			{Start: 10 * mb, End: 20*mb - 1},
+		}},
+		{"single segment", 8 * mb, 1, []PartSegment{
+			{Start: 0, End: 8*mb - 1},
+		}},
+	}
+	for _, tt := range tests {
+		got := splitSegments(tt.size, tt.count)
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: got %d segments, want %d", tt.name, len(got), len(tt.want))
+		}
+		for i, seg := range got {
+			if *seg != tt.want[i] {
+				t.Errorf("%s: segment %d = %+v, want %+v", tt.name, i, *seg, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestContiguousBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []*PartSegment
+		want     int64
+	}{
+		{"nothing written", []*PartSegment{{Start: 0, End: 9}, {Start: 10, End: 19}}, 0},
+		{"first one partly", []*PartSegment{{Start: 0, End: 9, Written: 4}, {Start: 10, End: 19, Written: 10}}, 4},
+		{"first one done", []*PartSegment{{Start: 0, End: 9, Written: 10}, {Start: 10, End: 19, Written: 3}}, 13},
+		{"gap in the middle", []*PartSegment{{Start: 0, End: 9, Written: 10}, {Start: 10, End: 19}, {Start: 20, End: 29, Written: 10}}, 10},
+		{"all done", []*PartSegment{{Start: 0, End: 9, Written: 10}, {Start: 10, End: 19, Written: 10}}, 20},
+		{"no segments", nil, 0},
+	}
+	for _, tt := range tests {
+		if got := contiguousBytes(tt.segments); got != tt.want {
+			t.Errorf("%s: contiguousBytes = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
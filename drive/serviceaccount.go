@@ -0,0 +1,172 @@
+package drive
+
+import (
+	"context"
+	"drivedlgo/db"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// Reasons of googleapi errors that are tied to the account making the
+// request, so another service account gets past them.
+var quotaReasons = map[string]bool{
+	"downloadQuotaExceeded": true,
+	"dailyLimitExceeded":    true,
+	"quotaExceeded":         true,
+	"userRateLimitExceeded": true,
+	"rateLimitExceeded":     true,
+}
+
+func isQuotaError(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	for _, item := range gerr.Errors {
+		if quotaReasons[item.Reason] {
+			return true
+		}
+	}
+	return false
+}
+
+// SetServiceAccountPool selects the pool Authorize uses with service
+// accounts, the default pool or the single setsa account if empty.
+func (G *GoogleDriveClient) SetServiceAccountPool(name string) {
+	G.saPool = name
+}
+
+// loadServiceAccounts returns the accounts of the selected pool. Without one
+// the single account stored by setsa counts as a pool of one.
+func (G *GoogleDriveClient) loadServiceAccounts(profile db.Profile) ([]db.ServiceAccount, error) {
+	name := G.saPool
+	if name == "" {
+		name = db.DEFAULT_SA_POOL
+	}
+	exists, err := db.IsSAPoolInDb(profile, name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return db.GetSAPoolDb(profile, name)
+	}
+	if G.saPool != "" {
+		return nil, fmt.Errorf("no service account pool named %s, add one with setsa --pool %s <dir>", G.saPool, G.saPool)
+	}
+	data, err := db.GetJWTConfigDb(profile)
+	if err != nil && err != db.ErrKeyNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get SA credentials from db, make sure to use setsa command: %v", err)
+	}
+	return []db.ServiceAccount{{Name: db.JWTCONFIG, Config: data}}, nil
+}
+
+func serviceAccountClient(account db.ServiceAccount) (*http.Client, error) {
+	// If modifying these scopes, delete your previously saved token.json.
+	config, err := google.JWTConfigFromJSON(account.Config, drive.DriveScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", account.Name, err)
+	}
+	return config.Client(context.Background()), nil
+}
+
+// authorizeServiceAccounts loads the pool and starts out on its first
+// usable account.
+func (G *GoogleDriveClient) authorizeServiceAccounts(profile db.Profile) (*http.Client, error) {
+	accounts, err := G.loadServiceAccounts(profile)
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) > 1 {
+		G.printf("Using Service Accounts: %d\n", len(accounts))
+	}
+	G.accounts = accounts
+	for i, account := range accounts {
+		client, err := serviceAccountClient(account)
+		if err != nil {
+			log.Printf("[ServiceAccountError]: %v\n", err)
+			continue
+		}
+		G.account = i
+		return client, nil
+	}
+	return nil, errors.New("no usable service account")
+}
+
+func (G *GoogleDriveClient) useClient(client *http.Client) error {
+	srv, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return err
+	}
+	G.accountMut.Lock()
+	defer G.accountMut.Unlock()
+	G.DriveSrv = srv
+	G.httpClient = client
+	return nil
+}
+
+// srv returns the Drive service of the account currently in use, which
+// changes when the pool rotates.
+func (G *GoogleDriveClient) srv() *drive.Service {
+	G.accountMut.RLock()
+	defer G.accountMut.RUnlock()
+	return G.DriveSrv
+}
+
+func (G *GoogleDriveClient) client() *http.Client {
+	G.accountMut.RLock()
+	defer G.accountMut.RUnlock()
+	return G.httpClient
+}
+
+// currentAccount returns the pool index of the account in use, to hand to
+// rotateAccount if what was started on it fails.
+func (G *GoogleDriveClient) currentAccount() int {
+	G.accountMut.RLock()
+	defer G.accountMut.RUnlock()
+	return G.account
+}
+
+// rotateAccount moves on to the next service account of the pool after err
+// hit a quota or rate limit of account, and reports whether whatever failed
+// should be tried again. Each account is only used once per client, so a
+// pool that's used up makes files fail as usual.
+func (R *jobRun) rotateAccount(account int, err error) bool {
+	if len(R.accounts) < 2 || !isQuotaError(err) || R.ctx.Err() != nil {
+		return false
+	}
+	R.accountMut.Lock()
+	defer R.accountMut.Unlock()
+	if R.account != account {
+		// Another worker hit the limit first and already moved on.
+		return true
+	}
+	for R.account < len(R.accounts)-1 {
+		R.account += 1
+		next := R.accounts[R.account]
+		client, clientErr := serviceAccountClient(next)
+		if clientErr != nil {
+			log.Printf("[ServiceAccountError]: %v\n", clientErr)
+			continue
+		}
+		srv, clientErr := drive.NewService(context.Background(), option.WithHTTPClient(client))
+		if clientErr != nil {
+			log.Printf("[ServiceAccountError]: %v\n", clientErr)
+			continue
+		}
+		log.Printf("[ServiceAccount]: %v, switching to %s\n", err, next.Name)
+		R.DriveSrv = srv
+		R.httpClient = client
+		return true
+	}
+	return false
+}
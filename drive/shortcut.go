@@ -0,0 +1,144 @@
+package drive
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const GDRIVE_SHORTCUT_MIMETYPE string = "application/vnd.google-apps.shortcut"
+
+const (
+	SHORTCUT_FOLLOW      string = "follow"
+	SHORTCUT_SKIP        string = "skip"
+	SHORTCUT_LINK        string = "link"
+	SHORTCUT_PLACEHOLDER string = "placeholder"
+)
+
+func (G *GoogleDriveClient) SetShortcutMode(mode string) error {
+	switch mode {
+	case "":
+		mode = SHORTCUT_FOLLOW
+	case SHORTCUT_FOLLOW, SHORTCUT_SKIP, SHORTCUT_LINK, SHORTCUT_PLACEHOLDER:
+	default:
+		return fmt.Errorf("unknown shortcut mode: %s", mode)
+	}
+	G.shortcutMode = mode
+	return nil
+}
+
+func shortcutTargetLink(file *drive.File) string {
+	return fmt.Sprintf("https://drive.google.com/open?id=%s", file.ShortcutDetails.TargetId)
+}
+
+// ResolveShortcut returns the target of a shortcut under the shortcut's own
+// name, so it lands in the local tree where the shortcut was.
+func (R *jobRun) ResolveShortcut(file *drive.File) (*drive.File, error) {
+	if file.ShortcutDetails == nil || file.ShortcutDetails.TargetId == "" {
+		return nil, fmt.Errorf("shortcut %s has no target", file.Id)
+	}
+	target, err := R.getFile(file.ShortcutDetails.TargetId)
+	if err != nil {
+		return nil, err
+	}
+	target.Name = file.Name
+	return target, nil
+}
+
+// HandleShortcut applies the shortcut mode to file. It returns the node that
+// should be walked or downloaded in place of the shortcut, or false when the
+// shortcut has been dealt with already.
+func (R *jobRun) HandleShortcut(file *drive.File, absPath string) (*drive.File, bool) {
+	switch R.shortcutMode {
+	case SHORTCUT_SKIP:
+		R.skipNode(file, absPath, PLAN_SKIPPED)
+		return nil, false
+	case SHORTCUT_LINK, SHORTCUT_PLACEHOLDER:
+		R.markRemote(absPath)
+		R.markRemote(absPath + ".url")
+		if file.ShortcutDetails == nil {
+			log.Printf("[ShortcutError]: shortcut %s has no target\n", file.Id)
+			return nil, false
+		}
+		if R.dryRun {
+			R.plan.Add(&PlanEntry{Path: R.relativePath(absPath), Id: file.Id, Action: PLAN_SHORTCUT})
+			return nil, false
+		}
+		if R.shortcutMode == SHORTCUT_LINK {
+			// The target may come later in the walk, see writeLinks.
+			R.links = append(R.links, pendingLink{file: file, absPath: absPath})
+			return nil, false
+		}
+		err := writePlaceholder(file, absPath)
+		if err != nil {
+			log.Printf("[ShortcutError]: %v\n", err)
+		}
+		return nil, false
+	}
+	target, err := R.ResolveShortcut(file)
+	if err != nil {
+		log.Printf("[ShortcutError]: (%s) %v\n", file.Id, err)
+		return nil, false
+	}
+	if target.MimeType == R.GDRIVE_DIR_MIMETYPE && R.walking[target.Id] {
+		log.Printf("[ShortcutError]: %s points to a parent folder, skipping to avoid a loop\n", file.Name)
+		return nil, false
+	}
+	return target, true
+}
+
+// pendingLink is a shortcut waiting for the walk to finish, so that it can
+// link to wherever its target ended up.
+type pendingLink struct {
+	file    *drive.File
+	absPath string
+}
+
+// writeLinks creates the symlinks of the shortcuts found since the last
+// call.
+func (R *jobRun) writeLinks() {
+	for _, link := range R.links {
+		err := R.writeLink(link.file, link.absPath)
+		if err != nil {
+			log.Printf("[ShortcutError]: %v\n", err)
+		}
+	}
+	R.links = nil
+}
+
+// writeLink symlinks absPath to the local copy of the shortcut's target. A
+// target outside the download, or a system that doesn't allow symlinks, gets
+// the .url placeholder instead.
+func (R *jobRun) writeLink(file *drive.File, absPath string) error {
+	if _, err := os.Lstat(absPath); err == nil {
+		return nil
+	}
+	if targetPath, ok := R.localPathOf(file.ShortcutDetails.TargetId); ok {
+		rel, err := filepath.Rel(filepath.Dir(absPath), targetPath)
+		if err == nil && os.Symlink(rel, absPath) == nil {
+			return nil
+		}
+	}
+	return writePlaceholder(file, absPath)
+}
+
+// localPathOf returns where the walk, or an earlier sync, placed fileId.
+func (R *jobRun) localPathOf(fileId string) (string, bool) {
+	if localPath, ok := R.localPaths[fileId]; ok {
+		return localPath, true
+	}
+	if R.syncIndex != nil {
+		for _, node := range R.syncIndex.copiesOf(fileId) {
+			return node.Path, true
+		}
+	}
+	return "", false
+}
+
+func writePlaceholder(file *drive.File, absPath string) error {
+	data := fmt.Sprintf("[InternetShortcut]\nURL=%s\nTargetMimeType=%s\n", shortcutTargetLink(file), file.ShortcutDetails.TargetMimeType)
+	return os.WriteFile(absPath+".url", []byte(data), 0644)
+}
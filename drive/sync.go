@@ -0,0 +1,575 @@
+package drive
+
+import (
+	"context"
+	"drivedlgo/db"
+	"drivedlgo/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/fatih/color"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const CHANGE_FIELDS string = "nextPageToken,newStartPageToken,changes(fileId,removed,file(" + FILE_FIELDS + ",parents,trashed))"
+
+// SyncState is saved per synced root, Token is the Changes API page token
+// the next sync continues from.
+type SyncState struct {
+	Token      string `json:"token"`
+	DriveId    string `json:"driveId,omitempty"`
+	LocalPath  string `json:"localPath"`
+	OutputPath string `json:"outputPath"`
+}
+
+// SyncNode maps a Drive id below a synced root to its local path. A followed
+// shortcut is kept under its own id, with the id of what it points to in
+// Target. Everything reached through it has the shortcut's key in Scope, so
+// a file that's in the tree more than once has a node for every copy.
+type SyncNode struct {
+	Id     string `json:"id"`
+	Path   string `json:"path"`
+	Folder bool   `json:"folder,omitempty"`
+	Scope  string `json:"scope,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+func (n *SyncNode) key() string {
+	return n.Scope + n.Id
+}
+
+// childScope returns the scope of the nodes inside folder node n.
+func (n *SyncNode) childScope() string {
+	return childScope(n.Scope, n.Id, n.Target)
+}
+
+// childScope returns the scope of what's inside a folder reached under
+// indexId, which differs from targetId for followed shortcuts.
+func childScope(scope string, indexId string, targetId string) string {
+	if targetId != "" && targetId != indexId {
+		return scope + indexId + "/"
+	}
+	return scope
+}
+
+// SyncIndex is the local view of a synced tree, keyed by SyncNode.key. Only
+// nodes that changed get written back to the database.
+type SyncIndex struct {
+	rootId  string
+	nodes   map[string]*SyncNode
+	dirty   map[string]bool
+	removed map[string]bool
+}
+
+func newSyncIndex(rootId string) *SyncIndex {
+	return &SyncIndex{rootId: rootId, nodes: make(map[string]*SyncNode), dirty: make(map[string]bool), removed: make(map[string]bool)}
+}
+
+func loadSyncIndex(store *db.Store, rootId string) (*SyncIndex, error) {
+	idx := newSyncIndex(rootId)
+	values, err := store.ScanValues(db.SyncNodesPrefix(rootId))
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		node := &SyncNode{}
+		err = json.Unmarshal(value, node)
+		if err != nil {
+			return nil, err
+		}
+		idx.nodes[node.key()] = node
+	}
+	return idx, nil
+}
+
+func (idx *SyncIndex) Add(node *SyncNode) {
+	key := node.key()
+	idx.nodes[key] = node
+	idx.dirty[key] = true
+	delete(idx.removed, key)
+}
+
+// Remove drops the node under key and, for folders, everything below it.
+func (idx *SyncIndex) Remove(key string) {
+	node, ok := idx.nodes[key]
+	if !ok {
+		return
+	}
+	for other, otherNode := range idx.nodes {
+		if other == key || node.Folder && strings.HasPrefix(otherNode.Path, node.Path+"/") {
+			delete(idx.nodes, other)
+			delete(idx.dirty, other)
+			idx.removed[other] = true
+		}
+	}
+}
+
+// Move updates the path of the node under key and of everything below it.
+func (idx *SyncIndex) Move(key string, newPath string) {
+	node := idx.nodes[key]
+	oldPath := node.Path
+	for other, otherNode := range idx.nodes {
+		if other == key {
+			otherNode.Path = newPath
+		} else if node.Folder && strings.HasPrefix(otherNode.Path, oldPath+"/") {
+			otherNode.Path = newPath + strings.TrimPrefix(otherNode.Path, oldPath)
+		} else {
+			continue
+		}
+		idx.dirty[other] = true
+	}
+}
+
+// copiesOf returns the nodes of fileId itself, one for every place it's in
+// the tree.
+func (idx *SyncIndex) copiesOf(fileId string) []*SyncNode {
+	var nodes []*SyncNode
+	for _, node := range idx.nodes {
+		if node.Id == fileId {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// shortcutsTo returns the nodes of followed shortcuts pointing to fileId.
+func (idx *SyncIndex) shortcutsTo(fileId string) []*SyncNode {
+	var nodes []*SyncNode
+	for _, node := range idx.nodes {
+		if node.Target == fileId {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// holders returns the local folders that mirror the Drive folder folderId,
+// the folder itself and every followed shortcut to it.
+func (idx *SyncIndex) holders(folderId string) []*SyncNode {
+	var nodes []*SyncNode
+	for _, node := range append(idx.copiesOf(folderId), idx.shortcutsTo(folderId)...) {
+		if node.Folder {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func (idx *SyncIndex) Save(store *db.Store) error {
+	for id := range idx.removed {
+		err := store.Delete(db.SyncNodeKey(idx.rootId, id))
+		if err != nil {
+			return err
+		}
+	}
+	for id := range idx.dirty {
+		data, err := json.Marshal(idx.nodes[id])
+		if err != nil {
+			return err
+		}
+		err = store.Put(db.SyncNodeKey(idx.rootId, id), data)
+		if err != nil {
+			return err
+		}
+	}
+	idx.dirty = make(map[string]bool)
+	idx.removed = make(map[string]bool)
+	return nil
+}
+
+func (idx *SyncIndex) clear(store *db.Store) error {
+	return store.DeletePrefix(db.SyncNodesPrefix(idx.rootId))
+}
+
+// indexNode records a node the walk placed locally while a sync is running.
+// indexId differs from the id of file for followed shortcuts, see SyncNode.
+func (R *jobRun) indexNode(scope string, indexId string, file *drive.File, absPath string, folder bool) {
+	if R.syncIndex == nil {
+		return
+	}
+	node := &SyncNode{Id: indexId, Path: absPath, Folder: folder, Scope: scope}
+	if indexId != file.Id {
+		node.Target = file.Id
+	}
+	R.syncIndex.Add(node)
+}
+
+func loadSyncState(store *db.Store, rootId string) *SyncState {
+	data, err := store.Get(db.SyncKey(rootId))
+	if err != nil {
+		return nil
+	}
+	state := &SyncState{}
+	if json.Unmarshal(data, state) != nil {
+		return nil
+	}
+	return state
+}
+
+func saveSyncState(store *db.Store, rootId string, state *SyncState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return store.Put(db.SyncKey(rootId), data)
+}
+
+// errSyncIndexMissing means the saved token can't be used because the
+// index it belongs to is gone.
+var errSyncIndexMissing = errors.New("sync index has no root")
+
+func isExpiredTokenError(err error) bool {
+	if gerr, ok := err.(*googleapi.Error); ok {
+		return gerr.Code == 400 || gerr.Code == 404 || gerr.Code == 410
+	}
+	return false
+}
+
+// Sync keeps localPath in line with nodeId. The first run, or a run whose
+// page token has expired, walks the whole tree like mirror does. Later runs
+// only fetch the changes since the saved page token.
+func (G *GoogleDriveClient) Sync(ctx context.Context, nodeId string, localPath string, outputPath string, opts MirrorOptions) error {
+	run := G.newRun(ctx)
+	closeStore, err := run.openStore()
+	if err != nil {
+		return fmt.Errorf("unable to open database: %v", err)
+	}
+	defer closeStore()
+	root, err := G.srv().Files.Get(nodeId).Fields("id,name,mimeType,driveId").SupportsAllDrives(true).Context(run.ctx).Do()
+	if err != nil {
+		return fmt.Errorf("unable to get %s: %w", nodeId, err)
+	}
+	if root.MimeType != G.GDRIVE_DIR_MIMETYPE {
+		return errors.New("sync only works on folders, use the download command for files")
+	}
+	state := loadSyncState(run.store, root.Id)
+	if state != nil && state.LocalPath == localPath && state.OutputPath == outputPath {
+		err = run.syncChanges(root, state, opts)
+		if err != errSyncIndexMissing && !isExpiredTokenError(err) {
+			return err
+		}
+		G.printf("%s", color.YellowString("Saved sync token is no longer valid, walking the whole folder again.\n"))
+	}
+	return run.fullSync(root, localPath, outputPath, opts)
+}
+
+func (R *jobRun) startPageToken(driveId string) (string, error) {
+	call := R.srv().Changes.GetStartPageToken().SupportsAllDrives(true)
+	if driveId != "" {
+		call = call.DriveId(driveId)
+	}
+	res, err := call.Context(R.ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return res.StartPageToken, nil
+}
+
+func (R *jobRun) fullSync(root *drive.File, localPath string, outputPath string, opts MirrorOptions) error {
+	// Take the token before walking so nothing that changes meanwhile is missed.
+	token, err := R.startPageToken(root.DriveId)
+	if err != nil {
+		return err
+	}
+	R.syncIndex = newSyncIndex(root.Id)
+	defer func() {
+		R.syncIndex = nil
+	}()
+	err = R.syncIndex.clear(R.store)
+	if err != nil {
+		return err
+	}
+	err = R.mirror(root.Id, localPath, outputPath, opts)
+	if err != nil {
+		// Without a token the next run walks everything again.
+		return err
+	}
+	err = R.syncIndex.Save(R.store)
+	if err != nil {
+		return err
+	}
+	return saveSyncState(R.store, root.Id, &SyncState{Token: token, DriveId: root.DriveId, LocalPath: localPath, OutputPath: outputPath})
+}
+
+func (R *jobRun) listChanges(state *SyncState) ([]*drive.Change, string, error) {
+	var changes []*drive.Change
+	pageToken := state.Token
+	for {
+		call := R.srv().Changes.List(pageToken).IncludeRemoved(true).SupportsAllDrives(true).IncludeItemsFromAllDrives(true).
+			PageSize(1000).Fields(googleapi.Field(CHANGE_FIELDS))
+		if state.DriveId != "" {
+			call = call.DriveId(state.DriveId)
+		}
+		res, err := call.Context(R.ctx).Do()
+		if err != nil {
+			return nil, "", err
+		}
+		changes = append(changes, res.Changes...)
+		if res.NewStartPageToken != "" {
+			return changes, res.NewStartPageToken, nil
+		}
+		pageToken = res.NextPageToken
+	}
+}
+
+func (R *jobRun) syncChanges(root *drive.File, state *SyncState, opts MirrorOptions) error {
+	changes, newToken, err := R.listChanges(state)
+	if err != nil {
+		return err
+	}
+	idx, err := loadSyncIndex(R.store, root.Id)
+	if err != nil {
+		return err
+	}
+	rootNode, ok := idx.nodes[root.Id]
+	if !ok {
+		return errSyncIndexMissing
+	}
+	R.printf("%s: %d changes since last sync\n", color.HiBlueString("Sync"), len(changes))
+	job, err := NewJob(R.store, root.Id, state.LocalPath, state.OutputPath)
+	if err != nil {
+		return err
+	}
+	R.job = job
+	R.rootPath = rootNode.Path
+	R.syncIndex = idx
+	R.listErrors = 0
+	defer func() {
+		R.syncIndex = nil
+	}()
+	R.applyChanges(root.Id, changes, opts)
+	// The journal holds all the changes asked for. It is resolved even when
+	// some couldn't be placed, runJob would walk the whole folder otherwise
+	// and those changes are fetched again as the token is kept.
+	listErrors := R.listErrors
+	err = job.SetResolved()
+	if err != nil {
+		log.Printf("[JournalError]: %v\n", err)
+	}
+	_, runErr := R.runJob(job)
+	err = idx.Save(R.store)
+	if err != nil {
+		return err
+	}
+	if runErr != nil {
+		// Keep the old token so the changes are fetched again next time.
+		return runErr
+	}
+	if listErrors > 0 {
+		return ErrListIncomplete
+	}
+	state.Token = newToken
+	return saveSyncState(R.store, root.Id, state)
+}
+
+// applyChanges updates the local tree for every change below rootId. Changes
+// are retried while they make progress, since a new file may come before the
+// new folder holding it.
+func (R *jobRun) applyChanges(rootId string, changes []*drive.Change, opts MirrorOptions) {
+	pending := changes
+	for len(pending) > 0 {
+		var next []*drive.Change
+		for _, change := range pending {
+			if !R.applyChange(rootId, change, opts) {
+				next = append(next, change)
+			}
+		}
+		if len(next) == len(pending) {
+			break
+		}
+		pending = next
+	}
+	defer R.writeLinks()
+	// Whatever is left has no parent in the index. Only what really left the
+	// tree is removed, the rest is below a folder that isn't synced, like an
+	// excluded one.
+	for _, change := range pending {
+		copies := R.syncIndex.copiesOf(change.FileId)
+		if len(copies) == 0 {
+			continue
+		}
+		below, err := R.isBelowRoot(change.File)
+		if err != nil {
+			log.Printf("[SyncError]: unable to tell where %s went: %v\n", change.FileId, err)
+			R.listErrors += 1
+			continue
+		}
+		if !below {
+			for _, node := range copies {
+				R.removeLocal(node.key(), opts)
+			}
+		}
+	}
+}
+
+// isBelowRoot follows the parents of file up until one of them is in the
+// index, which means file is still somewhere in the synced tree.
+func (R *jobRun) isBelowRoot(file *drive.File) (bool, error) {
+	seen := make(map[string]bool)
+	parents := file.Parents
+	for len(parents) > 0 {
+		var next []string
+		for _, parent := range parents {
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			if len(R.syncIndex.holders(parent)) > 0 {
+				return true, nil
+			}
+			folder, err := R.srv().Files.Get(parent).Fields("id,parents").SupportsAllDrives(true).Context(R.ctx).Do()
+			if err != nil {
+				return false, err
+			}
+			next = append(next, folder.Parents...)
+		}
+		parents = next
+	}
+	return false, nil
+}
+
+// applyChange returns false when no parent of the changed file is known
+// (yet).
+func (R *jobRun) applyChange(rootId string, change *drive.Change, opts MirrorOptions) bool {
+	if change.FileId == rootId {
+		return true
+	}
+	copies := R.syncIndex.copiesOf(change.FileId)
+	shortcuts := R.syncIndex.shortcutsTo(change.FileId)
+	if change.Removed || change.File == nil || change.File.Trashed {
+		for _, node := range append(copies, shortcuts...) {
+			R.removeLocal(node.key(), opts)
+		}
+		return true
+	}
+	file := change.File
+	// Followed shortcuts to a file hold it under their own name, they get
+	// its new content but stay where they are.
+	for _, node := range shortcuts {
+		if !node.Folder && R.filter.MatchMetadata(file) {
+			target := *file
+			target.Name = path.Base(node.Path)
+			R.queueFile(&target, node.Path, node.Scope, node.Id)
+		}
+	}
+	var holders []*SyncNode
+	for _, parent := range file.Parents {
+		holders = append(holders, R.syncIndex.holders(parent)...)
+	}
+	if len(holders) == 0 {
+		// A target outside the tree is done once its shortcuts are.
+		return len(copies) == 0 && len(shortcuts) > 0
+	}
+	placed := make(map[string]bool)
+	for _, holder := range holders {
+		placed[holder.childScope()+file.Id] = true
+		R.placeChange(file, holder, opts)
+	}
+	// A copy that isn't in any of those folders has been moved out of its
+	// own.
+	for _, node := range copies {
+		if !placed[node.key()] {
+			R.removeLocal(node.key(), opts)
+		}
+	}
+	return true
+}
+
+// placeChange brings the copy of file inside the local folder holder up to
+// date. A copy that was elsewhere in the same scope is moved there first.
+func (R *jobRun) placeChange(file *drive.File, holder *SyncNode, opts MirrorOptions) {
+	scope := holder.childScope()
+	key := scope + file.Id
+	absPath := path.Join(holder.Path, utils.CleanupFilename(file.Name))
+	// The target is what gets downloaded or walked, the index keeps the
+	// shortcut's id.
+	indexId := file.Id
+	if file.MimeType == GDRIVE_SHORTCUT_MIMETYPE {
+		target, ok := R.HandleShortcut(file, absPath)
+		if !ok {
+			return
+		}
+		file = target
+	}
+	folder := file.MimeType == R.GDRIVE_DIR_MIMETYPE
+	localPath := absPath
+	if ext, _, ok := R.exportFormat(file); ok && IsWorkspaceFile(file) {
+		localPath = exportPath(absPath, ext)
+	}
+	node, known := R.syncIndex.nodes[key]
+	if known && node.Path != localPath {
+		err := R.moveLocal(node.Path, localPath)
+		if err != nil {
+			log.Printf("[SyncError]: %v\n", err)
+		} else {
+			R.printf("%s %s -> %s\n", color.HiYellowString("moved"), R.relativePath(node.Path), R.relativePath(localPath))
+			R.syncIndex.Move(key, localPath)
+		}
+	}
+	relPath := R.relativePath(absPath)
+	if folder {
+		if known || !R.filter.MatchDir(relPath) {
+			return
+		}
+		// A new folder, or one moved in from outside, has to be walked since
+		// its children don't show up as changes of their own.
+		if !R.makeDir(file, absPath, scope, indexId) {
+			R.listErrors += 1
+			return
+		}
+		R.TraverseNodes(file.Id, absPath, childScope(scope, indexId, file.Id))
+	} else if R.filter.MatchFile(relPath) && R.filter.MatchMetadata(file) {
+		R.queueFile(file, absPath, scope, indexId)
+	}
+}
+
+// insideRoot reports whether absPath is strictly below the synced folder,
+// a sync never moves or removes anything else.
+func (R *jobRun) insideRoot(absPath string) bool {
+	return strings.HasPrefix(path.Clean(absPath), path.Clean(R.rootPath)+"/")
+}
+
+func (R *jobRun) moveLocal(oldPath string, newPath string) error {
+	if !R.insideRoot(oldPath) || !R.insideRoot(newPath) {
+		return fmt.Errorf("refusing to move %s to %s, outside of %s", oldPath, newPath, R.rootPath)
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		return nil
+	}
+	err := os.MkdirAll(path.Dir(newPath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (R *jobRun) removeLocal(key string, opts MirrorOptions) {
+	node, ok := R.syncIndex.nodes[key]
+	if !ok {
+		return
+	}
+	R.syncIndex.Remove(key)
+	if !R.insideRoot(node.Path) {
+		log.Printf("[SyncError]: refusing to remove %s, outside of %s\n", node.Path, R.rootPath)
+		return
+	}
+	var err error
+	if opts.BackupDir != "" {
+		rel := strings.TrimPrefix(strings.TrimPrefix(node.Path, R.rootPath), "/")
+		err = moveToBackup(node.Path, path.Join(opts.BackupDir, rel))
+	} else {
+		err = os.RemoveAll(node.Path)
+		removePart(node.Path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("[SyncError]: %v\n", err)
+		return
+	}
+	R.printf("%s %s\n", color.HiRedString("removed"), R.relativePath(node.Path))
+}
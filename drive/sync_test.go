@@ -0,0 +1,333 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+const folderMimeType string = "application/vnd.google-apps.folder"
+
+// fakeDrive serves the parts of the Drive API sync uses from memory. Every
+// call to Sync gets the changes set at that point.
+type fakeDrive struct {
+	mut     sync.Mutex
+	files   map[string]*drive.File
+	content map[string]string
+	changes []*drive.Change
+}
+
+func newFakeDrive() *fakeDrive {
+	return &fakeDrive{files: make(map[string]*drive.File), content: make(map[string]string)}
+}
+
+func (f *fakeDrive) add(file *drive.File, content string) {
+	f.files[file.Id] = file
+	f.content[file.Id] = content
+	file.Size = int64(len(content))
+}
+
+func (f *fakeDrive) folder(id string, name string, parents ...string) {
+	f.add(&drive.File{Id: id, Name: name, MimeType: folderMimeType, Parents: parents}, "")
+}
+
+func (f *fakeDrive) file(id string, name string, content string, parents ...string) {
+	f.add(&drive.File{Id: id, Name: name, MimeType: "text/plain", Parents: parents}, content)
+}
+
+func (f *fakeDrive) shortcut(id string, name string, targetId string, targetMimeType string, parents ...string) {
+	f.add(&drive.File{Id: id, Name: name, MimeType: GDRIVE_SHORTCUT_MIMETYPE, Parents: parents,
+		ShortcutDetails: &drive.FileShortcutDetails{TargetId: targetId, TargetMimeType: targetMimeType}}, "")
+}
+
+// change updates a file the way edit says and queues a change for it.
+func (f *fakeDrive) change(id string, content string, edit func(file *drive.File)) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	file := f.files[id]
+	edit(file)
+	if content != "" {
+		f.content[id] = content
+		file.Size = int64(len(content))
+	}
+	f.changes = append(f.changes, &drive.Change{FileId: id, File: file})
+}
+
+func (f *fakeDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	switch {
+	case r.URL.Path == "/changes/startPageToken":
+		enc.Encode(map[string]string{"startPageToken": "1"})
+	case r.URL.Path == "/changes":
+		enc.Encode(map[string]interface{}{"changes": f.changes, "newStartPageToken": "2"})
+		f.changes = nil
+	case r.URL.Path == "/files":
+		// Only "'<id>' in parents" queries are made.
+		parentId := strings.Split(r.URL.Query().Get("q"), "'")[1]
+		var children []*drive.File
+		for _, file := range f.files {
+			for _, parent := range file.Parents {
+				if parent == parentId && !file.Trashed {
+					children = append(children, file)
+				}
+			}
+		}
+		enc.Encode(map[string]interface{}{"files": children})
+	default:
+		id := strings.TrimPrefix(r.URL.Path, "/files/")
+		file, ok := f.files[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":{"code":404,"message":"File not found"}}`)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			io.WriteString(w, f.content[id])
+			return
+		}
+		enc.Encode(file)
+	}
+}
+
+func newFakeClient(t *testing.T, f *fakeDrive) *GoogleDriveClient {
+	ts := httptest.NewServer(f)
+	t.Cleanup(ts.Close)
+	G := NewDriveClient()
+	G.Init()
+	G.SetOutput(io.Discard)
+	G.SetEventSink(NopSink{})
+	G.SetCheckStrategy(CHECK_SIZE)
+	G.dbPath = filepath.Join(t.TempDir(), "db")
+	srv, err := drive.NewService(context.Background(), option.WithEndpoint(ts.URL+"/"), option.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	G.DriveSrv = srv
+	return G
+}
+
+// checkTree compares the files below dir with want, which maps slash
+// separated paths to their content.
+func checkTree(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		got[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, content := range want {
+		if got[p] != content {
+			t.Errorf("%s = %q, want %q", p, got[p], content)
+		}
+	}
+	for p := range got {
+		if _, ok := want[p]; !ok {
+			t.Errorf("unexpected file %s", p)
+		}
+	}
+}
+
+func testIndex() *SyncIndex {
+	idx := newSyncIndex("root")
+	for _, node := range []*SyncNode{
+		{Id: "root", Path: "/d/root", Folder: true},
+		{Id: "A", Path: "/d/root/A", Folder: true},
+		{Id: "a", Path: "/d/root/A/a.txt"},
+		{Id: "B", Path: "/d/root/A/B", Folder: true},
+		{Id: "b", Path: "/d/root/A/B/b.txt"},
+		{Id: "c", Path: "/d/root/AB/c.txt"},
+		{Id: "S", Path: "/d/root/S", Folder: true, Target: "A"},
+		{Id: "a", Path: "/d/root/S/a.txt", Scope: "S/"},
+	} {
+		idx.Add(node)
+	}
+	return idx
+}
+
+func TestSyncIndexMove(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		newPath string
+		want    map[string]string
+	}{
+		{"file", "a", "/d/root/A/renamed.txt", map[string]string{
+			"a": "/d/root/A/renamed.txt", "S/a": "/d/root/S/a.txt", "b": "/d/root/A/B/b.txt",
+		}},
+		{"folder with its contents", "A", "/d/root/Z", map[string]string{
+			"A": "/d/root/Z", "a": "/d/root/Z/a.txt", "B": "/d/root/Z/B", "b": "/d/root/Z/B/b.txt",
+			"c": "/d/root/AB/c.txt", "S/a": "/d/root/S/a.txt",
+		}},
+		{"copy through a shortcut", "S/a", "/d/root/S/moved.txt", map[string]string{
+			"S/a": "/d/root/S/moved.txt", "a": "/d/root/A/a.txt",
+		}},
+	}
+	for _, tt := range tests {
+		idx := testIndex()
+		idx.dirty = make(map[string]bool)
+		idx.Move(tt.key, tt.newPath)
+		for key, want := range tt.want {
+			if got := idx.nodes[key].Path; got != want {
+				t.Errorf("%s: path of %s = %q, want %q", tt.name, key, got, want)
+			}
+		}
+		if !idx.dirty[tt.key] {
+			t.Errorf("%s: %s isn't marked to be saved", tt.name, tt.key)
+		}
+	}
+}
+
+func TestSyncIndexRemove(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		gone []string
+		kept []string
+	}{
+		{"file", "a", []string{"a"}, []string{"S/a", "A", "b"}},
+		{"folder with its contents", "A", []string{"A", "a", "B", "b"}, []string{"c", "S", "S/a", "root"}},
+		{"followed shortcut", "S", []string{"S", "S/a"}, []string{"a", "A"}},
+		{"unknown key", "x", nil, []string{"root", "A", "a", "B", "b", "c", "S", "S/a"}},
+	}
+	for _, tt := range tests {
+		idx := testIndex()
+		idx.Remove(tt.key)
+		for _, key := range tt.gone {
+			if _, ok := idx.nodes[key]; ok {
+				t.Errorf("%s: %s is still in the index", tt.name, key)
+			}
+			if !idx.removed[key] {
+				t.Errorf("%s: %s isn't marked removed", tt.name, key)
+			}
+		}
+		for _, key := range tt.kept {
+			if _, ok := idx.nodes[key]; !ok {
+				t.Errorf("%s: %s was removed", tt.name, key)
+			}
+		}
+	}
+}
+
+func TestSyncShortcuts(t *testing.T) {
+	f := newFakeDrive()
+	f.folder("root", "root")
+	f.folder("T", "T", "root")
+	f.file("t", "t.txt", "one", "T")
+	f.shortcut("S", "S", "T", folderMimeType, "root")
+	// O is outside the synced tree, only reached through U.
+	f.folder("elsewhere", "elsewhere")
+	f.folder("O", "O", "elsewhere")
+	f.file("o", "o.txt", "out", "O")
+	f.shortcut("U", "U", "O", folderMimeType, "root")
+	G := newFakeClient(t, f)
+	dir := t.TempDir()
+	sync := func() {
+		t.Helper()
+		err := G.Sync(context.Background(), "root", dir, "", MirrorOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sync()
+	checkTree(t, dir, map[string]string{"root/T/t.txt": "one", "root/S/t.txt": "one", "root/U/o.txt": "out"})
+
+	// Both copies of t follow the rename, o is updated in place rather than
+	// removed as a leftover.
+	f.change("t", "two!", func(file *drive.File) { file.Name = "t2.txt" })
+	f.change("o", "out2", func(file *drive.File) {})
+	sync()
+	checkTree(t, dir, map[string]string{"root/T/t2.txt": "two!", "root/S/t2.txt": "two!", "root/U/o.txt": "out2"})
+
+	// o leaves the folder U points to.
+	f.change("o", "", func(file *drive.File) { file.Parents = []string{"elsewhere"} })
+	sync()
+	checkTree(t, dir, map[string]string{"root/T/t2.txt": "two!", "root/S/t2.txt": "two!"})
+
+	// Trashing the target of S removes both copies.
+	f.change("T", "", func(file *drive.File) { file.Trashed = true })
+	sync()
+	checkTree(t, dir, map[string]string{})
+}
+
+func TestSyncLeftovers(t *testing.T) {
+	f := newFakeDrive()
+	f.folder("root", "root")
+	f.folder("X", "X", "root")
+	f.folder("Y", "Y", "X")
+	f.folder("elsewhere", "elsewhere")
+	f.file("a", "a.txt", "a", "root")
+	f.file("b", "b.txt", "b", "root")
+	f.file("c", "c.txt", "c", "root")
+	G := newFakeClient(t, f)
+	filter, err := NewFilter(nil, []string{"/X"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	G.SetFilter(filter)
+	dir := t.TempDir()
+	err = G.Sync(context.Background(), "root", dir, "", MirrorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, dir, map[string]string{"root/a.txt": "a", "root/b.txt": "b", "root/c.txt": "c"})
+
+	// a moves below the excluded folder, which is still inside the tree, b
+	// leaves the tree and the parent c moved to can't be looked up.
+	f.change("a", "", func(file *drive.File) { file.Parents = []string{"Y"} })
+	f.change("b", "", func(file *drive.File) { file.Parents = []string{"elsewhere"} })
+	f.change("c", "", func(file *drive.File) { file.Parents = []string{"gone"} })
+	err = G.Sync(context.Background(), "root", dir, "", MirrorOptions{})
+	if !errors.Is(err, ErrListIncomplete) {
+		t.Fatalf("Sync = %v, want %v", err, ErrListIncomplete)
+	}
+	checkTree(t, dir, map[string]string{"root/a.txt": "a", "root/c.txt": "c"})
+}
+
+func TestSyncUnsafeNames(t *testing.T) {
+	f := newFakeDrive()
+	f.folder("root", "root")
+	f.folder("d", "..", "root")
+	f.file("x", "x.txt", "x", "root")
+	G := newFakeClient(t, f)
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("keep"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = G.Sync(context.Background(), "root", dir, "", MirrorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.change("d", "", func(file *drive.File) { file.Trashed = true })
+	f.change("x", "", func(file *drive.File) { file.Name = "../../escape.txt" })
+	err = G.Sync(context.Background(), "root", dir, "", MirrorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, dir, map[string]string{"keep.txt": "keep", "root/.._.._escape.txt": "x"})
+}
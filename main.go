@@ -1,22 +1,53 @@
 package main
 
 import (
+	"context"
 	"drivedlgo/db"
 	"drivedlgo/drive"
 	"drivedlgo/utils"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"regexp"
+	"syscall"
+	"time"
 
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
 	"github.com/urfave/cli"
 )
 
+// Exit codes, so scripts can tell why a run failed.
+const (
+	EXIT_OK        int = 0
+	EXIT_ERROR     int = 1
+	EXIT_PARTIAL   int = 2
+	EXIT_AUTH      int = 3
+	EXIT_NOT_FOUND int = 4
+)
+
 const DRIVE_LINK_REGEX string = `https://drive\.google\.com/(drive)?/?u?/?\d?/?(mobile)?/?(file)?(folders)?/?d?/([-\w]+)[?+]?/?(w+)?`
 
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return EXIT_OK
+	case drive.IsAuthError(err):
+		return EXIT_AUTH
+	case drive.IsNotFound(err):
+		return EXIT_NOT_FOUND
+	case errors.Is(err, drive.ErrIncomplete), errors.Is(err, drive.ErrListIncomplete), errors.Is(err, context.Canceled):
+		// Whatever got downloaded is kept and the job can be resumed.
+		return EXIT_PARTIAL
+	}
+	return EXIT_ERROR
+}
+
 func getFileIdByLink(link string) string {
 	match := regexp.MustCompile(DRIVE_LINK_REGEX)
 	matches := match.FindStringSubmatch(link)
@@ -39,22 +70,158 @@ func getFileIdByLink(link string) string {
 	return ""
 }
 
-func downloadCallback(c *cli.Context) error {
-	arg := c.Args().Get(0)
-	if arg == "" {
-		return errors.New(fmt.Sprintf("Required argument <fileid/link> is missing. \nUsage: %s\nFor more info: %s --help ", c.App.UsageText, os.Args[0]))
+// runContext is cancelled on Ctrl-C, so a download stops cleanly and can be
+// resumed later.
+func runContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// messages is where commands print informational lines, stderr when stdout
+// carries JSON events.
+func messages(c *cli.Context) io.Writer {
+	if c.String("output-format") == "jsonl" {
+		return os.Stderr
 	}
-	fileId := getFileIdByLink(arg)
-	if fileId == "" {
-		fileId = arg
+	return os.Stdout
+}
+
+// setupOutput picks how GD reports progress, see --output-format.
+func setupOutput(c *cli.Context, GD *drive.GoogleDriveClient) error {
+	switch c.String("output-format") {
+	case "text":
+		return setupProgress(c, GD)
+	case "jsonl":
+		// Nothing but events may go to stdout.
+		color.NoColor = true
+		GD.SetOutput(os.Stderr)
+		GD.SetEventSink(drive.NewJSONSink(os.Stdout, progressInterval(c, time.Second)))
+	default:
+		return fmt.Errorf("unknown output format: %s", c.String("output-format"))
+	}
+	return nil
+}
+
+// setupProgress shows progress bars in a terminal and plain status lines
+// otherwise, see --progress.
+func setupProgress(c *cli.Context, GD *drive.GoogleDriveClient) error {
+	mode := c.String("progress")
+	if mode == "auto" {
+		mode = "plain"
+		if isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd()) {
+			mode = "bars"
+		}
 	}
-	fmt.Printf("Detected File-Id: %s\n", fileId)
+	switch mode {
+	case "bars":
+		// Log output is printed above the progress bars.
+		sink := drive.NewBarSink(os.Stdout)
+		GD.SetEventSink(sink)
+		log.SetOutput(sink)
+	case "plain":
+		GD.SetEventSink(drive.NewPlainSink(os.Stdout, progressInterval(c, 30*time.Second)))
+	default:
+		return fmt.Errorf("unknown progress mode: %s", c.String("progress"))
+	}
+	return nil
+}
+
+func progressInterval(c *cli.Context, fallback time.Duration) time.Duration {
+	if interval := c.Duration("progress-interval"); interval > 0 {
+		return interval
+	}
+	return fallback
+}
+
+func newDriveClient(c *cli.Context) (*drive.GoogleDriveClient, error) {
 	GD := drive.NewDriveClient()
 	GD.Init()
-	GD.Authorize(c.String("db-path"), c.Bool("usesa"), c.Int("port"))
+	err := setupOutput(c, GD)
+	if err != nil {
+		return nil, err
+	}
+	p, err := profile(c)
+	if err != nil {
+		return nil, err
+	}
+	GD.SetProfile(p.Name)
+	GD.SetServiceAccountPool(c.String("sa-pool"))
+	err = GD.Authorize(p.Path, c.Bool("usesa"), c.Int("port"))
+	if err != nil {
+		return nil, err
+	}
 	GD.SetConcurrency(c.Int("conn"))
 	GD.SetAbusiveFileDownload(c.Bool("acknowledge-abuse"))
-	cus_path, err := db.GetDLDirDb(c.String("db-path"))
+	GD.SetSegments(c.Int("segments"))
+	GD.SetRetryPolicy(drive.RetryPolicy{
+		MaxRetries: c.Int("retries"),
+		BaseDelay:  c.Duration("retry-delay"),
+		MaxDelay:   c.Duration("retry-max-delay"),
+	})
+	err = GD.SetRateLimit(c.String("limit-rate"), c.String("limit-rate-file"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse rate limit: %v", err)
+	}
+	err = GD.SetExportFormats(c.String("export"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse export formats: %v", err)
+	}
+	err = GD.SetShortcutMode(c.String("shortcuts"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to set shortcut mode: %v", err)
+	}
+	filter, err := drive.NewFilter(c.StringSlice("include"), c.StringSlice("exclude"), c.StringSlice("include-regex"), c.StringSlice("exclude-regex"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse filters: %v", err)
+	}
+	filter.Metadata, err = metadataFilterFromFlags(c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse filters: %v", err)
+	}
+	GD.SetFilter(filter)
+	minFree, err := utils.ParseByteSize(c.String("min-free"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse minimum free space: %v", err)
+	}
+	GD.SetMinFreeSpace(minFree, c.Bool("force"))
+	GD.SetPreserveTimes(!c.Bool("no-preserve-times"), c.Bool("preserve-created-time"))
+	err = GD.SetCheckStrategy(c.String("check"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to set check strategy: %v", err)
+	}
+	return GD, nil
+}
+
+func metadataFilterFromFlags(c *cli.Context) (*drive.MetadataFilter, error) {
+	var err error
+	m := &drive.MetadataFilter{MimeTypes: c.StringSlice("mime"), Owners: c.StringSlice("owner")}
+	m.MinSize, err = utils.ParseByteSize(c.String("min-size"))
+	if err != nil {
+		return nil, err
+	}
+	m.MaxSize, err = utils.ParseByteSize(c.String("max-size"))
+	if err != nil {
+		return nil, err
+	}
+	m.ModifiedAfter, err = utils.ParseTime(c.String("modified-after"))
+	if err != nil {
+		return nil, err
+	}
+	m.ModifiedBefore, err = utils.ParseTime(c.String("modified-before"))
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func downloadPath(c *cli.Context) (string, error) {
+	p, err := profile(c)
+	if err != nil {
+		return "", err
+	}
+	cus_path, err := db.GetDLDirDb(p)
+	if err != nil && err != db.ErrKeyNotFound {
+		return "", err
+	}
 	if err == nil {
 		if c.String("path") == "." {
 			path.Join(cus_path, c.String("path"))
@@ -64,8 +231,164 @@ func downloadCallback(c *cli.Context) error {
 	} else {
 		cus_path = c.String("path")
 	}
-	log.SetOutput(GD.Progress)
-	GD.Download(fileId, cus_path, c.String("output"))
+	return cus_path, nil
+}
+
+func downloadCallback(c *cli.Context) error {
+	arg := c.Args().Get(0)
+	if arg == "" {
+		return errors.New(fmt.Sprintf("Required argument <fileid/link> is missing. \nUsage: %s\nFor more info: %s --help ", c.App.UsageText, os.Args[0]))
+	}
+	fileId := getFileIdByLink(arg)
+	if fileId == "" {
+		fileId = arg
+	}
+	fmt.Fprintf(messages(c), "Detected File-Id: %s\n", fileId)
+	GD, err := newDriveClient(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext()
+	defer cancel()
+	cus_path, err := downloadPath(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("dry-run") {
+		return dryRun(ctx, c, GD, fileId, cus_path)
+	}
+	_, err = GD.Download(ctx, fileId, cus_path, drive.Options{Output: c.String("output")})
+	return err
+}
+
+func dryRun(ctx context.Context, c *cli.Context, GD *drive.GoogleDriveClient, fileId string, localPath string) error {
+	rate, err := utils.ParseByteSize(c.String("estimate-rate"))
+	if err != nil {
+		return err
+	}
+	plan, err := GD.DryRun(ctx, fileId, localPath, c.String("output"))
+	if err != nil {
+		return err
+	}
+	plan.Estimate(rate)
+	switch c.String("dry-run-format") {
+	case "json":
+		return plan.WriteJSON(os.Stdout)
+	case "tree":
+		plan.WriteTree(messages(c))
+		return nil
+	}
+	return fmt.Errorf("unknown dry-run format: %s", c.String("dry-run-format"))
+}
+
+func mirrorCallback(c *cli.Context) error {
+	arg := c.Args().Get(0)
+	if arg == "" {
+		return errors.New("Required argument <fileid/link> is missing.")
+	}
+	fileId := getFileIdByLink(arg)
+	if fileId == "" {
+		fileId = arg
+	}
+	fmt.Fprintf(messages(c), "Detected File-Id: %s\n", fileId)
+	GD, err := newDriveClient(c)
+	if err != nil {
+		return err
+	}
+	cus_path, err := downloadPath(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext()
+	defer cancel()
+	return GD.Mirror(ctx, fileId, cus_path, c.String("output"), drive.MirrorOptions{
+		DryRun:     c.Bool("dry-run"),
+		BackupDir:  c.String("backup-dir"),
+		PlanOutput: messages(c),
+	})
+}
+
+func syncCallback(c *cli.Context) error {
+	arg := c.Args().Get(0)
+	if arg == "" {
+		return errors.New("Required argument <fileid/link> is missing.")
+	}
+	if c.Bool("dry-run") {
+		return errors.New("--dry-run is not supported by sync, use mirror --dry-run instead.")
+	}
+	fileId := getFileIdByLink(arg)
+	if fileId == "" {
+		fileId = arg
+	}
+	fmt.Fprintf(messages(c), "Detected File-Id: %s\n", fileId)
+	GD, err := newDriveClient(c)
+	if err != nil {
+		return err
+	}
+	cus_path, err := downloadPath(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext()
+	defer cancel()
+	return GD.Sync(ctx, fileId, cus_path, c.String("output"), drive.MirrorOptions{
+		BackupDir: c.String("backup-dir"),
+	})
+}
+
+func resumeCallback(c *cli.Context) error {
+	jobId := c.Args().Get(0)
+	if jobId == "" {
+		return errors.New("Provide the job-id printed by an interrupted download.")
+	}
+	fmt.Fprintf(messages(c), "Detected Job-Id: %s\n", jobId)
+	GD, err := newDriveClient(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext()
+	defer cancel()
+	_, err = GD.Resume(ctx, jobId)
+	return err
+}
+
+func lsCallback(c *cli.Context) error {
+	arg := c.Args().Get(0)
+	if arg == "" {
+		return errors.New("Required argument <fileid/link> is missing.")
+	}
+	fileId := getFileIdByLink(arg)
+	if fileId == "" {
+		fileId = arg
+	}
+	p, err := profile(c)
+	if err != nil {
+		return err
+	}
+	GD := drive.NewDriveClient()
+	GD.Init()
+	// stdout is reserved for the listing, so that it can be piped.
+	GD.SetOutput(os.Stderr)
+	GD.SetProfile(p.Name)
+	GD.SetServiceAccountPool(c.String("sa-pool"))
+	err = GD.Authorize(p.Path, c.Bool("usesa"), c.Int("port"))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext()
+	defer cancel()
+	entries, err := GD.List(ctx, fileId, drive.ListOptions{
+		Recursive: c.Bool("recursive"),
+		SortBy:    c.String("sort"),
+		Reverse:   c.Bool("reverse"),
+	})
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		return drive.WriteListJSON(os.Stdout, entries)
+	}
+	drive.WriteList(os.Stdout, entries, c.Bool("long"))
 	return nil
 }
 
@@ -74,52 +397,234 @@ func setCredsCallback(c *cli.Context) error {
 	if arg == "" {
 		return errors.New("Provide a proper credentials.json file path.")
 	}
+	p, err := profile(c)
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Detected credentials.json Path: %s\n", arg)
-	if !db.IsCredentialsInDb(c.String("db-path")) {
-		if db.IsTokenInDb(c.String("db-path")) {
-			db.RemoveTokenDb(c.String("db-path"))
-		}
-		db.AddCredentialsDb(c.String("db-path"), arg)
-		fmt.Printf("%s added in database.\n", arg)
-	} else {
+	exists, err := db.IsCredentialsInDb(p)
+	if err != nil {
+		return err
+	}
+	if exists {
 		fmt.Println("A credentials file already exists in databse, use rm command to remove it first.")
+		return nil
 	}
+	hasToken, err := db.IsTokenInDb(p)
+	if err != nil {
+		return err
+	}
+	if hasToken {
+		_, err = db.RemoveTokenDb(p)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = db.AddCredentialsDb(p, arg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s added in database.\n", arg)
 	return nil
 }
 
 func rmCredsCallback(c *cli.Context) error {
-	if db.IsCredentialsInDb(c.String("db-path")) {
-		db.RemoveCredentialsDb(c.String("db-path"))
-		db.RemoveTokenDb(c.String("db-path"))
-		fmt.Println("credentials removed from database successfully.")
-	} else {
+	p, err := profile(c)
+	if err != nil {
+		return err
+	}
+	exists, err := db.IsCredentialsInDb(p)
+	if err != nil {
+		return err
+	}
+	if !exists {
 		fmt.Println("Database doesnt contain any credentials.")
+		return nil
+	}
+	_, err = db.RemoveCredentialsDb(p)
+	if err != nil {
+		return err
+	}
+	hasToken, err := db.IsTokenInDb(p)
+	if err != nil {
+		return err
 	}
+	if hasToken {
+		_, err = db.RemoveTokenDb(p)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("credentials removed from database successfully.")
 	return nil
 }
 
 func setJWTConfigCallback(c *cli.Context) error {
 	arg := c.Args().Get(0)
 	if arg == "" {
-		return errors.New("Provide a proper service account file path.")
+		return errors.New("Provide a proper service account file or directory path.")
+	}
+	p, err := profile(c)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(arg)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		pool := c.String("pool")
+		if pool == "" {
+			pool = db.DEFAULT_SA_POOL
+		}
+		count, err := db.AddSAPoolDb(p, pool, arg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d service accounts from %s added in database as pool %s.\n", count, arg, pool)
+		return nil
 	}
 	fmt.Printf("Detected service account Path: %s\n", arg)
-	if !db.IsJWTConfigInDb(c.GlobalString("db-path")) {
-		db.AddJWTConfigDb(c.GlobalString("db-path"), arg)
-		fmt.Printf("%s added in database.\n", arg)
-	} else {
+	exists, err := db.IsJWTConfigInDb(p)
+	if err != nil {
+		return err
+	}
+	if exists {
 		fmt.Println("A service account already exists in databse, use rmsa command to remove it first.")
+		return nil
+	}
+	_, err = db.AddJWTConfigDb(p, arg)
+	if err != nil {
+		return err
 	}
+	fmt.Printf("%s added in database.\n", arg)
 	return nil
 }
 
 func rmJWTConfigCallback(c *cli.Context) error {
-	if db.IsJWTConfigInDb(c.GlobalString("db-path")) {
-		db.RemoveJWTConfigDb(c.GlobalString("db-path"))
-		fmt.Println("service account removed from database successfully.")
+	p, err := profile(c)
+	if err != nil {
+		return err
+	}
+	pool := c.String("pool")
+	if pool == "" {
+		exists, err := db.IsJWTConfigInDb(p)
+		if err != nil {
+			return err
+		}
+		if exists {
+			_, err = db.RemoveJWTConfigDb(p)
+			if err != nil {
+				return err
+			}
+			fmt.Println("service account removed from database successfully.")
+		} else {
+			fmt.Println("Database doesnt contain any service account.")
+		}
+		pool = db.DEFAULT_SA_POOL
+	}
+	exists, err := db.IsSAPoolInDb(p, pool)
+	if err != nil {
+		return err
+	}
+	if exists {
+		_, err = db.RemoveSAPoolDb(p, pool)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("service account pool %s removed from database successfully.\n", pool)
+	} else if c.String("pool") != "" {
+		fmt.Printf("Database doesnt contain a service account pool named %s.\n", pool)
+	}
+	return nil
+}
+
+// profile returns the profile of --profile, or the default one.
+func profile(c *cli.Context) (db.Profile, error) {
+	p := db.Profile{Path: c.String("db-path"), Name: c.String("profile")}
+	if p.Name == "" {
+		p.Name = c.GlobalString("profile")
+	}
+	if p.Name == "" {
+		name, err := db.GetDefaultProfileDb(p.Path)
+		if err != nil {
+			return p, err
+		}
+		p.Name = name
+	}
+	exists, err := db.IsProfileInDb(p.Path, p.Name)
+	if err != nil {
+		return p, err
+	}
+	if !exists {
+		return p, fmt.Errorf("profile %s doesn't exist, add it with: %s profiles add %s", p.Name, os.Args[0], p.Name)
+	}
+	return p, nil
+}
+
+func listProfilesCallback(c *cli.Context) error {
+	names, err := db.GetProfilesDb(c.String("db-path"))
+	if err != nil {
+		return err
+	}
+	def, err := db.GetDefaultProfileDb(c.String("db-path"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		mark := " "
+		if name == def {
+			mark = "*"
+		}
+		fmt.Printf("%s %s\n", mark, name)
+	}
+	return nil
+}
+
+func addProfileCallback(c *cli.Context) error {
+	arg := c.Args().Get(0)
+	if arg == "" {
+		return errors.New("Provide a profile name.")
+	}
+	added, err := db.AddProfileDb(c.String("db-path"), arg)
+	if err != nil {
+		return err
+	}
+	if added {
+		fmt.Printf("Profile %s added, use --profile %s with set, setsa or setdldir to configure it.\n", arg, arg)
 	} else {
-		fmt.Println("Database doesnt contain any service account.")
+		fmt.Printf("Profile %s already exists.\n", arg)
+	}
+	return nil
+}
+
+func rmProfileCallback(c *cli.Context) error {
+	arg := c.Args().Get(0)
+	if arg == "" {
+		return errors.New("Provide a profile name.")
+	}
+	removed, err := db.RemoveProfileDb(c.String("db-path"), arg)
+	if err != nil {
+		return err
+	}
+	if removed {
+		fmt.Printf("Profile %s removed from database successfully.\n", arg)
+	} else {
+		fmt.Printf("Database doesnt contain a profile named %s.\n", arg)
+	}
+	return nil
+}
+
+func setDefaultProfileCallback(c *cli.Context) error {
+	arg := c.Args().Get(0)
+	if arg == "" {
+		return errors.New("Provide a profile name.")
+	}
+	err := db.SetDefaultProfileDb(c.String("db-path"), arg)
+	if err != nil {
+		return err
 	}
+	fmt.Printf("%s is the default profile now.\n", arg)
 	return nil
 }
 
@@ -128,31 +633,41 @@ func setDLDirCallback(c *cli.Context) error {
 	if arg == "" {
 		return errors.New("Provide a proper download directory path.")
 	}
-	fmt.Printf("Detected download directory path: %s\n", arg)
-	_, err := db.GetDLDirDb(c.String("db-path"))
-	if err == nil {
-		db.RemoveDLDirDb(c.String("db-path"))
+	p, err := profile(c)
+	if err != nil {
+		return err
 	}
-	_, err = db.AddDLDirDb(c.String("db-path"), arg)
+	fmt.Printf("Detected download directory path: %s\n", arg)
+	_, err = db.AddDLDirDb(p, arg)
 	return err
 }
 
 func rmDLDirCallback(c *cli.Context) error {
-	_, err := db.GetDLDirDb(c.String("db-path"))
+	p, err := profile(c)
 	if err != nil {
+		return err
+	}
+	_, err = db.GetDLDirDb(p)
+	if err == db.ErrKeyNotFound {
 		fmt.Println("DB doesnt contain default directory path, try --help.")
-	} else {
-		_, err = db.RemoveDLDirDb(c.String("db-path"))
-		if err == nil {
-			fmt.Println("Default directory removed successfully, now application will download in current working directory.")
-		} else {
-			fmt.Println("Error while removing default directory: ", err.Error())
-		}
+		return nil
+	}
+	if err != nil {
+		return err
 	}
+	_, err = db.RemoveDLDirDb(p)
+	if err != nil {
+		return fmt.Errorf("Error while removing default directory: %v", err)
+	}
+	fmt.Println("Default directory removed successfully, now application will download in current working directory.")
 	return nil
 }
 
 func main() {
+	profileFlag := &cli.StringFlag{
+		Name:  "profile",
+		Usage: "Profile whose credentials, service accounts and download directory are used, see the profiles command.",
+	}
 	dlFlags := []cli.Flag{
 		&cli.StringFlag{
 			Name:  "path",
@@ -168,11 +683,139 @@ func main() {
 			Usage: "File path to store the database.",
 			Value: utils.GetDefaultDbPath(),
 		},
+		profileFlag,
 		&cli.IntFlag{
 			Name:  "conn",
 			Usage: "Number of Concurrent File Downloads.",
 			Value: 2,
 		},
+		&cli.IntFlag{
+			Name:  "segments",
+			Usage: "Number of Concurrent Connections per File, splits large files into byte ranges.",
+			Value: 1,
+		},
+		&cli.StringFlag{
+			Name:  "limit-rate",
+			Usage: "Maximum download speed across all transfers, e.g. 500K, 20M, 1G.",
+		},
+		&cli.StringFlag{
+			Name:  "limit-rate-file",
+			Usage: "Maximum download speed of a single file, e.g. 500K, 20M, 1G.",
+		},
+		&cli.StringFlag{
+			Name:  "export",
+			Usage: "Export formats for Google Docs files, e.g. document=pdf,spreadsheet=csv,presentation=odp.",
+		},
+		&cli.StringFlag{
+			Name:  "shortcuts",
+			Usage: "How to handle Drive shortcuts: follow, skip, link (symlink to the target when it is part of the download, .url file otherwise) or placeholder (.url file).",
+			Value: "follow",
+		},
+		&cli.StringSliceFlag{
+			Name:  "include",
+			Usage: "Only download files whose path matches this glob, e.g. *.mkv. Can be repeated.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "Skip files and folders whose path matches this glob, e.g. */raw/*. Can be repeated.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "include-regex",
+			Usage: "Only download files whose path matches this regular expression. Can be repeated.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude-regex",
+			Usage: "Skip files and folders whose path matches this regular expression. Can be repeated.",
+		},
+		&cli.StringFlag{
+			Name:  "min-size",
+			Usage: "Skip files smaller than this size, e.g. 100M.",
+		},
+		&cli.StringFlag{
+			Name:  "max-size",
+			Usage: "Skip files larger than this size, e.g. 4G.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "mime",
+			Usage: "Only download files of this MIME type, e.g. video/mp4 or video/*. Can be repeated.",
+		},
+		&cli.StringFlag{
+			Name:  "modified-after",
+			Usage: "Only download files modified after this date (2006-01-02 or RFC3339).",
+		},
+		&cli.StringFlag{
+			Name:  "modified-before",
+			Usage: "Only download files modified before this date (2006-01-02 or RFC3339).",
+		},
+		&cli.StringSliceFlag{
+			Name:  "owner",
+			Usage: "Only download files owned by this email address or name. Can be repeated.",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only print what would be downloaded, resumed or skipped along with totals.",
+		},
+		&cli.StringFlag{
+			Name:  "dry-run-format",
+			Usage: "Output of --dry-run: tree or json.",
+			Value: "tree",
+		},
+		&cli.StringFlag{
+			Name:  "estimate-rate",
+			Usage: "Download speed --dry-run estimates the transfer time with, e.g. 20M.",
+			Value: "10M",
+		},
+		&cli.StringFlag{
+			Name:  "min-free",
+			Usage: "Free space to keep at the destination, downloads pause when it drops below this, e.g. 1G. Off by default.",
+			Value: "0",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Start downloading even if the files don't fit in the free space at the destination.",
+		},
+		&cli.BoolFlag{
+			Name:  "no-preserve-times",
+			Usage: "Don't set the modification time of downloaded files and folders to the one on Drive.",
+		},
+		&cli.BoolFlag{
+			Name:  "preserve-created-time",
+			Usage: "Also set the creation time of downloaded files and folders, only supported on Windows.",
+		},
+		&cli.StringFlag{
+			Name:  "check",
+			Usage: "How existing files are checked: size, mtime (falls back to md5) or md5.",
+			Value: "md5",
+		},
+		&cli.StringFlag{
+			Name:  "output-format",
+			Usage: "How progress is reported: text (progress bars) or jsonl (one JSON event per line on stdout).",
+			Value: "text",
+		},
+		&cli.StringFlag{
+			Name:  "progress",
+			Usage: "How progress is shown: bars, plain (status lines for logs) or auto (bars only in a terminal).",
+			Value: "auto",
+		},
+		&cli.DurationFlag{
+			Name:  "progress-interval",
+			Usage: "How often progress is reported, 1s for --output-format=jsonl and 30s for --progress=plain by default.",
+		},
+		&cli.IntFlag{
+			Name:  "retries",
+			Usage: "How often a failed request is retried before giving up on it.",
+			Value: drive.MAX_RETRIES,
+		},
+		&cli.DurationFlag{
+			Name:  "retry-delay",
+			Usage: "Initial wait before a retry, doubled with every further attempt.",
+			Value: drive.DEFAULT_RETRY_DELAY,
+		},
+		&cli.DurationFlag{
+			Name:  "retry-max-delay",
+			Usage: "Longest wait between retries, unless Drive asks for longer through Retry-After.",
+			Value: drive.DEFAULT_RETRY_MAX_DELAY,
+		},
 		&cli.BoolFlag{
 			Name:  "acknowledge-abuse",
 			Usage: "Enable downloading of files marked as abusive by google drive.",
@@ -181,19 +824,72 @@ func main() {
 			Name:  "usesa",
 			Usage: "Use service accounts instead of OAuth.",
 		},
+		&cli.StringFlag{
+			Name:  "sa-pool",
+			Usage: "Service-account pool to use with --usesa, rotated through when an account hits its quota.",
+		},
 		&cli.IntFlag{
 			Name:  "port",
 			Usage: "Port for the OAuth web server.",
 			Value: 8096,
 		},
 	}
-	subCommandFlags := []cli.Flag{
+	dbFlags := []cli.Flag{
 		&cli.StringFlag{
 			Name:  "db-path",
 			Usage: "File path to store the database.",
 			Value: utils.GetDefaultDbPath(),
 		},
 	}
+	subCommandFlags := append([]cli.Flag{profileFlag}, dbFlags...)
+	saFlags := append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "pool",
+			Usage: "Name of the service-account pool a directory is stored as, default if not set.",
+		},
+	}, subCommandFlags...)
+	lsFlags := append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "recursive, R",
+			Usage: "List sub-folders recursively.",
+		},
+		&cli.BoolFlag{
+			Name:  "long, l",
+			Usage: "Show size, modified time, MIME type, id and md5 of each entry.",
+		},
+		&cli.StringFlag{
+			Name:  "sort",
+			Usage: "Sort entries of each folder by name, size, modified or mime.",
+			Value: "name",
+		},
+		&cli.BoolFlag{
+			Name:  "reverse, r",
+			Usage: "Reverse the sort order.",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print entries as JSON.",
+		},
+		&cli.BoolFlag{
+			Name:  "usesa",
+			Usage: "Use service accounts instead of OAuth.",
+		},
+		&cli.StringFlag{
+			Name:  "sa-pool",
+			Usage: "Service-account pool to use with --usesa, rotated through when an account hits its quota.",
+		},
+		&cli.IntFlag{
+			Name:  "port",
+			Usage: "Port for the OAuth web server.",
+			Value: 8096,
+		},
+	}, subCommandFlags...)
+	mirrorFlags := append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "backup-dir",
+			Usage: "Move local files that are no longer on Drive here instead of deleting them.",
+		},
+	}, dlFlags...)
 	app := cli.NewApp()
 	app.Name = "Google Drive Downloader"
 	app.Usage = "A minimal Google Drive Downloader written in Go."
@@ -204,6 +900,34 @@ func main() {
 	app.Action = downloadCallback
 	app.Flags = dlFlags
 	app.Commands = []cli.Command{
+		{
+			Name:      "resume",
+			Usage:     "resume an interrupted download from its job journal",
+			ArgsUsage: "<job-id>",
+			Action:    resumeCallback,
+			Flags:     dlFlags,
+		},
+		{
+			Name:      "mirror",
+			Usage:     "download a folder and remove local files that are no longer on drive",
+			ArgsUsage: "<fileid/link>",
+			Action:    mirrorCallback,
+			Flags:     mirrorFlags,
+		},
+		{
+			Name:      "sync",
+			Usage:     "keep a local copy of a folder up to date using drive's change feed",
+			ArgsUsage: "<fileid/link>",
+			Action:    syncCallback,
+			Flags:     mirrorFlags,
+		},
+		{
+			Name:      "ls",
+			Usage:     "list the contents of a drive folder",
+			ArgsUsage: "<fileid/link>",
+			Action:    lsCallback,
+			Flags:     lsFlags,
+		},
 		{
 			Name:   "set",
 			Usage:  "add credentials.json file to database",
@@ -218,15 +942,15 @@ func main() {
 		},
 		{
 			Name:   "setsa",
-			Usage:  "add service account to database",
+			Usage:  "add a service account, or a directory of them as a pool, to database",
 			Action: setJWTConfigCallback,
-			Flags:  subCommandFlags,
+			Flags:  saFlags,
 		},
 		{
 			Name:   "rmsa",
-			Usage:  "remove service account from database",
+			Usage:  "remove service account or service-account pool from database",
 			Action: rmJWTConfigCallback,
-			Flags:  subCommandFlags,
+			Flags:  saFlags,
 		},
 		{
 			Name:   "setdldir",
@@ -240,10 +964,46 @@ func main() {
 			Action: rmDLDirCallback,
 			Flags:  subCommandFlags,
 		},
+		{
+			Name:   "profiles",
+			Usage:  "list, add, remove and set the default of the profiles in database",
+			Action: listProfilesCallback,
+			Flags:  dbFlags,
+			Subcommands: []cli.Command{
+				{
+					Name:   "list",
+					Usage:  "list profiles, the default one is marked with *",
+					Action: listProfilesCallback,
+					Flags:  dbFlags,
+				},
+				{
+					Name:      "add",
+					Usage:     "add a profile",
+					ArgsUsage: "<name>",
+					Action:    addProfileCallback,
+					Flags:     dbFlags,
+				},
+				{
+					Name:      "rm",
+					Usage:     "remove a profile with its credentials, service accounts and download directory",
+					ArgsUsage: "<name>",
+					Action:    rmProfileCallback,
+					Flags:     dbFlags,
+				},
+				{
+					Name:      "default",
+					Usage:     "set the profile used when --profile isn't given",
+					ArgsUsage: "<name>",
+					Action:    setDefaultProfileCallback,
+					Flags:     dbFlags,
+				},
+			},
+		},
 	}
 	app.Version = "1.6"
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
 	}
+	os.Exit(exitCode(err))
 }
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package utils
+
+import "syscall"
+
+// GetFreeSpace returns the bytes available to unprivileged users on the
+// filesystem holding dirPath.
+func GetFreeSpace(dirPath string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(existingParent(dirPath), &stat)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
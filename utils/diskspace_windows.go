@@ -0,0 +1,20 @@
+//go:build windows
+
+package utils
+
+import "golang.org/x/sys/windows"
+
+// GetFreeSpace returns the bytes available to the current user on the volume
+// holding dirPath.
+func GetFreeSpace(dirPath string) (uint64, error) {
+	dir, err := windows.UTF16PtrFromString(existingParent(dirPath))
+	if err != nil {
+		return 0, err
+	}
+	var free, total, totalFree uint64
+	err = windows.GetDiskFreeSpaceEx(dir, &free, &total, &totalFree)
+	if err != nil {
+		return 0, err
+	}
+	return free, nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package utils
+
+import "time"
+
+// SetCreationTime is a no-op, unix filesystems don't allow setting the
+// creation time.
+func SetCreationTime(filePath string, ctime time.Time) error {
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package utils
+
+import (
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// SetCreationTime sets the creation time of filePath, which only Windows
+// lets us change.
+func SetCreationTime(filePath string, ctime time.Time) error {
+	name, err := windows.UTF16PtrFromString(filePath)
+	if err != nil {
+		return err
+	}
+	handle, err := windows.CreateFile(name, windows.FILE_WRITE_ATTRIBUTES, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(handle)
+	ft := windows.NsecToFiletime(ctime.UnixNano())
+	return windows.SetFileTime(handle, &ft, nil, nil)
+}
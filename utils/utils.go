@@ -10,9 +10,11 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/OpenPeeDeeP/xdg"
 	"golang.org/x/oauth2"
@@ -51,15 +53,20 @@ func GetFileMd5(filePath string) (string, error) {
 	return returnMD5String, nil
 }
 
-func fileExists(filename string) bool {
-	info, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		return false
+// existingParent walks up from dirPath until it finds a path that exists, so
+// free space can be checked before the download folder is created.
+func existingParent(dirPath string) string {
+	dirPath = filepath.Clean(dirPath)
+	for {
+		if _, err := os.Stat(dirPath); err == nil {
+			return dirPath
+		}
+		parent := filepath.Dir(dirPath)
+		if parent == dirPath {
+			return dirPath
+		}
+		dirPath = parent
 	}
-	if info == nil {
-		return false
-	}
-	return !info.IsDir()
 }
 
 func GetFileSize(filePath string) (int64, error) {
@@ -71,25 +78,6 @@ func GetFileSize(filePath string) (int64, error) {
 	return size, nil
 }
 
-func CheckLocalFile(filePath, driveFileHash string) (bool, int64, error) {
-	var fileSize int64
-	if !fileExists(filePath) {
-		return false, 0, nil
-	}
-	hash, err := GetFileMd5(filePath)
-	if err != nil {
-		return false, 0, err
-	}
-	fileSize, err = GetFileSize(filePath)
-	if err != nil {
-		return false, 0, err
-	}
-	if hash == driveFileHash {
-		return true, fileSize, nil
-	}
-	return false, fileSize, nil
-}
-
 func OauthTokenToBytes(token *oauth2.Token) []byte {
 	var buffer bytes.Buffer
 	enc := gob.NewEncoder(&buffer)
@@ -106,10 +94,62 @@ func StringToInt(str string) (int, error) {
 	return i, nil
 }
 
+// ParseByteSize parses sizes like "512K", "20M" or "1.5G" into bytes. Units are
+// powers of 1024, an empty string or "0" means no size.
+func ParseByteSize(str string) (int64, error) {
+	str = strings.TrimSpace(strings.ToUpper(str))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	if str == "" {
+		return 0, nil
+	}
+	multiplier := float64(1)
+	switch str[len(str)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	case 'T':
+		multiplier = 1 << 40
+	}
+	if multiplier != 1 {
+		str = str[:len(str)-1]
+	}
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %s", str)
+	}
+	return int64(value * multiplier), nil
+}
+
+// ParseTime accepts either a date like 2006-01-02 or a full RFC3339 timestamp,
+// an empty string gives the zero time.
+func ParseTime(str string) (time.Time, error) {
+	if str == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, str)
+	if err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", str, time.Local)
+}
+
+// CleanupFilename turns a Drive name into a single path element. Separators
+// are replaced and names like ".." that would point elsewhere are escaped,
+// since a Drive name can be anything.
 func CleanupFilename(name string) string {
 	for _, char := range []string{"\"", "?", "&", "*", "@", "!", "'", ":"} {
 		name = strings.ReplaceAll(name, char, "")
 	}
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "" {
+		return "_"
+	}
+	if strings.Trim(name, ".") == "" {
+		return strings.Repeat("_", len(name))
+	}
 	return name
 }
 
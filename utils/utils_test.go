@@ -0,0 +1,28 @@
+package utils
+
+import "testing"
+
+func TestCleanupFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"what? really!", "what really"},
+		{"a/b", "a_b"},
+		{`a\b`, "a_b"},
+		{"../../escape.txt", ".._.._escape.txt"},
+		{"..", "__"},
+		{".", "_"},
+		{"...", "___"},
+		{"", "_"},
+		{"?*", "_"},
+		{"..?", "__"},
+		{".hidden", ".hidden"},
+	}
+	for _, tt := range tests {
+		if got := CleanupFilename(tt.name); got != tt.want {
+			t.Errorf("CleanupFilename(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}